@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
+	// Calendar windows are computed in IANA time zones, so every node has to
+	// use the same zone database regardless of the host.
+	_ "time/tzdata"
 
 	"github.com/spf13/viper"
 
@@ -14,6 +18,7 @@ import (
 type configRaft struct {
 	Port      int    `mapstructure:"port"`
 	VolumeDir string `mapstructure:"volume_dir"`
+	Shards    int    `mapstructure:"shards"`
 }
 
 type configServer struct {
@@ -24,12 +29,23 @@ type configDiscovery struct {
 	Port int `mapstructure:"port"`
 }
 
+type configRateLimit struct {
+	Limit      int           `mapstructure:"limit"`
+	Window     time.Duration `mapstructure:"window"`
+	Burst      int           `mapstructure:"burst"`
+	RefillRate string        `mapstructure:"refill_rate"`
+	LeaseSize  int           `mapstructure:"lease_size"`
+	LeaseTTL   time.Duration `mapstructure:"lease_ttl"`
+	Backend    string        `mapstructure:"backend"`
+}
+
 type cfg struct {
 	NodeID            string          `mapstructure:"node_id"`
 	Server            configServer    `mapstructure:"server"`
 	Raft              configRaft      `mapstructure:"raft"`
 	Discovery         configDiscovery `mapstructure:"discovery"`
 	DiscoveryClusters []string        `mapstructure:"discoveryClusters"`
+	RateLimit         configRateLimit `mapstructure:"rate_limit"`
 }
 
 const (
@@ -37,8 +53,16 @@ const (
 	serverPort        = "SERVER_PORT"
 	raftPort          = "RAFT_PORT"
 	raftVolDir        = "RAFT_VOL_DIR"
+	raftShards        = "RAFT_SHARDS"
 	discoveryPort     = "DISCOVERY_PORT"
 	discoveryClusters = "CLUSTERS"
+	rateLimit         = "RATE_LIMIT"
+	rateWindow        = "RATE_WINDOW"
+	rateBurst         = "RATE_BURST"
+	rateRefill        = "RATE_REFILL"
+	quotaLeaseSize    = "QUOTA_LEASE_SIZE"
+	quotaLeaseTTL     = "QUOTA_LEASE_TTL"
+	rateBackend       = "RATE_BACKEND"
 )
 
 var confKeys = []string{
@@ -46,8 +70,16 @@ var confKeys = []string{
 	nodeId,
 	raftPort,
 	raftVolDir,
+	raftShards,
 	discoveryPort,
 	discoveryClusters,
+	rateLimit,
+	rateWindow,
+	rateBurst,
+	rateRefill,
+	quotaLeaseSize,
+	quotaLeaseTTL,
+	rateBackend,
 }
 
 func main() {
@@ -68,11 +100,21 @@ func main() {
 		Raft: configRaft{
 			Port:      v.GetInt(raftPort),
 			VolumeDir: v.GetString(raftVolDir),
+			Shards:    v.GetInt(raftShards),
 		},
 		Discovery: configDiscovery{
 			Port: v.GetInt(discoveryPort),
 		},
 		DiscoveryClusters: clusterList,
+		RateLimit: configRateLimit{
+			Limit:      v.GetInt(rateLimit),
+			Window:     v.GetDuration(rateWindow),
+			Burst:      v.GetInt(rateBurst),
+			RefillRate: v.GetString(rateRefill),
+			LeaseSize:  v.GetInt(quotaLeaseSize),
+			LeaseTTL:   v.GetDuration(quotaLeaseTTL),
+			Backend:    v.GetString(rateBackend),
+		},
 	}
 
 	bindAddr := fmt.Sprintf("127.0.0.1:%d", conf.Raft.Port)
@@ -83,13 +125,23 @@ func main() {
 			NodeID:   conf.NodeID,
 			BindAddr: bindAddr,
 			DataDir:  conf.Raft.VolumeDir,
+			Shards:   conf.Raft.Shards,
 		}, &config.ConfigMembership{
 			NodeName: conf.NodeID,
 			BindAddr: fmt.Sprintf("127.0.0.1:%d", conf.Discovery.Port),
 			Tags: map[string]string{
 				"raft_addr": bindAddr,
+				"api_addr":  fmt.Sprintf("127.0.0.1:%d", conf.Server.Port),
 			},
 			StartJoinAddrs: conf.DiscoveryClusters,
+		}, &config.ConfigRateLimiter{
+			Limit:      conf.RateLimit.Limit,
+			Window:     conf.RateLimit.Window,
+			Burst:      conf.RateLimit.Burst,
+			RefillRate: conf.RateLimit.RefillRate,
+			LeaseSize:  conf.RateLimit.LeaseSize,
+			LeaseTTL:   conf.RateLimit.LeaseTTL,
+			Backend:    conf.RateLimit.Backend,
 		},
 	)
 	agent.Launch()
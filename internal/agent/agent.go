@@ -19,16 +19,20 @@ type Agent struct {
 	cfgAPI        *config.ConfigAPI
 	cfgRaft       *config.ConfigRaft
 	cfgMemberShip *config.ConfigMembership
-	ratelimiter   *ratelimiter.RateLimiter
-	raftNode      *raft.Raft
-	membership    *discovery.DiscoveryAgent
+	cfgLimiter    *config.ConfigRateLimiter
+	// ratelimiters and raftNodes hold the state and Raft group of every shard.
+	ratelimiters []*ratelimiter.RateLimiter
+	raftNodes    []*raft.Raft
+	membership   *discovery.DiscoveryAgent
 }
 
-func NewAgent(cfgAPI *config.ConfigAPI, cfgRaft *config.ConfigRaft, cfgMemberShip *config.ConfigMembership) *Agent {
+func NewAgent(cfgAPI *config.ConfigAPI, cfgRaft *config.ConfigRaft, cfgMemberShip *config.ConfigMembership,
+	cfgLimiter *config.ConfigRateLimiter) *Agent {
 	return &Agent{
 		cfgAPI:        cfgAPI,
 		cfgRaft:       cfgRaft,
 		cfgMemberShip: cfgMemberShip,
+		cfgLimiter:    cfgLimiter,
 	}
 }
 
@@ -43,12 +47,25 @@ func (a *Agent) Launch() {
 		log.Printf("Data directory %s already exists", dataDir)
 	}
 
-	limiter := ratelimiter.NewRateLimiter()
-	a.ratelimiter = limiter
+	policy, err := a.defaultPolicy()
+	if err != nil {
+		log.Fatalf("Failed to parse default rate limit policy: %v", err)
+	}
+	if err := policy.Validate(); err != nil {
+		log.Fatalf("Invalid default rate limit policy: %v", err)
+	}
+	for i := 0; i < max(1, a.cfgRaft.Shards); i++ {
+		a.ratelimiters = append(a.ratelimiters, ratelimiter.NewRateLimiter())
+	}
 
 	if err := a.initRaft(dataDir); err != nil {
 		log.Fatalf("Failed to create Raft node: %v", err)
 	}
+	// The default policy is applied by the FSM, so it is replicated by the
+	// leader instead of being set on every node from its own configuration.
+	for shard, raftNode := range a.raftNodes {
+		go distributed.ReplicateDefaultPolicy(raftNode, a.ratelimiters[shard], policy)
+	}
 
 	if err := a.initMembership(); err != nil {
 		log.Fatalf("Failed to create membership: %v", err)
@@ -59,24 +76,74 @@ func (a *Agent) Launch() {
 	}
 }
 
-func (a *Agent) initRaft(dataDir string) error {
-	raftNode, err := distributed.NewRaft(
-		&config.ConfigRaft{
-			NodeID:   a.cfgRaft.NodeID,
-			BindAddr: a.cfgRaft.BindAddr,
-			DataDir:  dataDir,
-		}, a.ratelimiter)
-	if err != nil {
-		return err
+func (a *Agent) defaultPolicy() (ratelimiter.Policy, error) {
+	policy := ratelimiter.DefaultPolicy()
+	if a.cfgLimiter == nil {
+		return policy, nil
 	}
+	rule := &policy.Rules[0]
+	if a.cfgLimiter.Limit > 0 {
+		rule.Limit = a.cfgLimiter.Limit
+	}
+	if a.cfgLimiter.Window > 0 {
+		rule.Window = a.cfgLimiter.Window
+	}
+	if a.cfgLimiter.Burst > 0 {
+		rule.Burst = a.cfgLimiter.Burst
+	}
+	if a.cfgLimiter.RefillRate != "" {
+		rate, err := ratelimiter.ParseRate(a.cfgLimiter.RefillRate)
+		if err != nil {
+			return policy, err
+		}
+		rule.RefillRate = rate
+	}
+	policy.Backend = ratelimiter.Backend(a.cfgLimiter.Backend)
+	return policy, nil
+}
 
-	log.Printf("Raft node: %v created", raftNode)
-	a.raftNode = raftNode
+func (a *Agent) initRaft(dataDir string) error {
+	for shard, limiter := range a.ratelimiters {
+		bindAddr, err := distributed.ShardBindAddr(a.cfgRaft.BindAddr, shard)
+		if err != nil {
+			return err
+		}
+		shardDir := distributed.ShardDataDir(dataDir, shard)
+		if err := os.MkdirAll(shardDir, os.ModePerm); err != nil {
+			return err
+		}
+
+		raftNode, err := distributed.NewRaft(
+			&config.ConfigRaft{
+				NodeID:   a.cfgRaft.NodeID,
+				BindAddr: bindAddr,
+				DataDir:  shardDir,
+			}, limiter)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Raft node: %v of shard %d created", raftNode, shard)
+		a.raftNodes = append(a.raftNodes, raftNode)
+	}
 	return nil
 }
 
 func (a *Agent) initMembership() error {
-	membership, err := discovery.New(a.cfgMemberShip, a.raftNode)
+	// Advertise the Raft address of every shard so that the other nodes can
+	// add this one to all of their groups.
+	if a.cfgMemberShip.Tags == nil {
+		a.cfgMemberShip.Tags = make(map[string]string)
+	}
+	for shard := 1; shard < len(a.raftNodes); shard++ {
+		bindAddr, err := distributed.ShardBindAddr(a.cfgRaft.BindAddr, shard)
+		if err != nil {
+			return err
+		}
+		a.cfgMemberShip.Tags[discovery.RaftAddrTag(shard)] = bindAddr
+	}
+
+	membership, err := discovery.New(a.cfgMemberShip, a.raftNodes...)
 	if err != nil {
 		return err
 	}
@@ -89,18 +156,56 @@ func (a *Agent) launchAPI() error {
 	router := gin.Default()
 
 	raftHandler := &api.RaftHandler{
-		RaftNode: a.raftNode,
+		RaftNode: a.raftNodes[0],
+		Shards:   a.raftNodes,
 	}
 
 	router.GET("/raft/stats", raftHandler.StatsRaftHandler)
 
-	apiHandler := &api.APIHandler{
-		RateLimiter: a.ratelimiter,
-		RaftNode:    a.raftNode,
+	var quotaLeases *api.QuotaLeases
+	if a.cfgLimiter != nil && a.cfgLimiter.LeaseSize > 0 {
+		quotaLeases = &api.QuotaLeases{
+			NodeID: a.cfgRaft.NodeID,
+			Size:   a.cfgLimiter.LeaseSize,
+			TTL:    a.cfgLimiter.LeaseTTL,
+		}
+	}
+	shards := &api.ShardRouter{}
+	for shard, raftNode := range a.raftNodes {
+		shards.Shards = append(shards.Shards, &api.APIHandler{
+			RateLimiter: a.ratelimiters[shard],
+			RaftNode:    raftNode,
+			Clock:       ratelimiter.SystemClock(),
+			Members:     a.membership,
+			Gossip:      a.membership,
+			Batcher:     distributed.NewBatcher(raftNode, distributed.DefaultMaxBatchSize),
+			QuotaLeases: quotaLeases,
+		})
 	}
-	router.GET("/rate/check", apiHandler.CheckQuotaHandler)
-	router.POST("/rate/increment", apiHandler.IncrementQuotaHandler)
-	router.POST("/rate/reset", apiHandler.ResetQuotaHandler)
+	handle := shards.Handle
+
+	router.GET("/rate/check", handle((*api.APIHandler).CheckQuotaHandler))
+	router.GET("/policy", shards.GetPolicyHandler)
+	// Increments of gossip policies and increments covered by a local quota
+	// slice are answered before anything is forwarded to the leader.
+	router.POST("/rate/increment", handle((*api.APIHandler).GossipQuota), handle((*api.APIHandler).LocalQuota),
+		handle((*api.APIHandler).ForwardToLeader), handle((*api.APIHandler).IncrementQuotaHandler))
+
+	// Writes go through Raft, so followers hand them over to the leader of
+	// the client's shard.
+	writes := router.Group("", handle((*api.APIHandler).ForwardToLeader))
+	writes.POST("/quota/grant", handle((*api.APIHandler).GrantQuotaHandler))
+	writes.POST("/rate/reset", handle((*api.APIHandler).ResetQuotaHandler))
+	writes.POST("/rate/shape", handle((*api.APIHandler).ShapeRequestHandler))
+	writes.POST("/rate/wait", handle((*api.APIHandler).WaitHandler))
+	writes.POST("/rate/feedback", handle((*api.APIHandler).FeedbackHandler))
+	writes.POST("/rate/reserve", handle((*api.APIHandler).ReserveHandler))
+	writes.POST("/rate/cancel", handle((*api.APIHandler).CancelReservationHandler))
+	writes.POST("/concurrency/acquire", handle((*api.APIHandler).AcquireConcurrencyHandler))
+	writes.POST("/concurrency/release", handle((*api.APIHandler).ReleaseConcurrencyHandler))
+	writes.POST("/policy", handle((*api.APIHandler).SetPolicyHandler))
+	writes.PUT("/policy", handle((*api.APIHandler).SetPolicyHandler))
+	writes.DELETE("/policy", handle((*api.APIHandler).DeletePolicyHandler))
 
 	serverPort := fmt.Sprintf(":%d", a.cfgAPI.Port)
 	log.Printf("Rate limiter running on %s", serverPort)
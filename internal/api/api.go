@@ -1,9 +1,11 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,6 +18,18 @@ import (
 type APIHandler struct {
 	RateLimiter *ratelimiter.RateLimiter
 	RaftNode    *raft.Raft
+	Clock       ratelimiter.Clock
+	// Members locates the leader's API so that followers can forward writes.
+	Members MemberDirectory
+	// Batcher, if set, coalesces concurrent increments into batch entries.
+	Batcher *distributed.Batcher
+	// QuotaLeases, if set, lets this node admit increments from local slices
+	// of the clients' quotas.
+	QuotaLeases *QuotaLeases
+	// Gossip decides increments of policies with the gossip backend.
+	Gossip GossipLimiter
+
+	waiters waitQueue
 }
 
 func (h *APIHandler) CheckQuotaHandler(c *gin.Context) {
@@ -25,7 +39,21 @@ func (h *APIHandler) CheckQuotaHandler(c *gin.Context) {
 		return
 	}
 
-	remaining := h.RateLimiter.CheckQuota(clientID)
+	consistency, err := parseConsistency(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Consistency must be stale, default or consistent."})
+		return
+	}
+	if consistency != ConsistencyStale && h.RaftNode.State() != raft.Leader {
+		h.forward(c)
+		return
+	}
+
+	remaining, err := h.checkQuota(clientID, consistency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"result": true, "remaining_quota": remaining})
 }
 
@@ -36,36 +64,65 @@ func (h *APIHandler) IncrementQuotaHandler(c *gin.Context) {
 		return
 	}
 
+	cost, err := parseCost(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid cost."})
+		return
+	}
+
 	cmd := distributed.RateLimitCommand{
 		Action:   distributed.Increment,
 		ClientID: clientID,
+		Cost:     cost,
 	}
 
-	data, err := json.Marshal(cmd)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+	decision, ok := h.applyIncrement(c, cmd)
+	if !ok {
 		return
 	}
 
-	applyFuture := h.RaftNode.Apply(data, 500*time.Millisecond)
-	if err := applyFuture.Error(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+	writeDecision(c, decision)
+}
+
+// ShapeRequestHandler queues a request of a leaky bucket client and tells the
+// caller how long to delay it so that requests leave at a constant rate.
+func (h *APIHandler) ShapeRequestHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing client_id."})
+		return
+	}
+	if !h.RateLimiter.GetPolicy(clientID).Uses(ratelimiter.LeakyBucket) {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Policy of client_id does not use leaky_bucket."})
 		return
 	}
 
-	response, ok := applyFuture.Response().(*distributed.ApplyResponse)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": "Error response is not matched"})
+	cost, err := parseCost(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid cost."})
 		return
 	}
 
-	if response.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("Error response: %s", response.Error.Error())})
+	cmd := distributed.RateLimitCommand{
+		Action:   distributed.Increment,
+		ClientID: clientID,
+		Cost:     cost,
+	}
+
+	decision, ok := h.applyIncrement(c, cmd)
+	if !ok {
 		return
 	}
 
-	result := response.Data.(bool)
-	c.JSON(http.StatusOK, gin.H{"result": result})
+	if !decision.Allowed {
+		writeDecision(c, decision)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"result":          true,
+		"delay_ms":        decision.Delay.Milliseconds(),
+		"queue_remaining": decision.Remaining,
+	})
 }
 
 func (h *APIHandler) ResetQuotaHandler(c *gin.Context) {
@@ -78,26 +135,107 @@ func (h *APIHandler) ResetQuotaHandler(c *gin.Context) {
 	cmd := distributed.RateLimitCommand{
 		Action:    distributed.Reset,
 		ClientID:  clientID,
-		ResetTime: time.Now().Unix(),
+		ResetTime: h.now().Unix(),
 	}
 
-	data, err := json.Marshal(cmd)
-	if err != nil {
+	if _, err := h.applyCommand(cmd); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"result": true})
+}
 
-	applyFuture := h.RaftNode.Apply(data, 500*time.Millisecond)
-	if err := applyFuture.Error(); err != nil {
+// applyIncrement replicates an increment and extracts its decision. The
+// optional request_id query parameter makes retries of it idempotent. On
+// failure it writes the error response and returns false.
+func (h *APIHandler) applyIncrement(c *gin.Context, cmd distributed.RateLimitCommand) (ratelimiter.Decision, bool) {
+	cmd.IdempotencyKey = c.Query("request_id")
+	response, err := h.applyCommand(cmd)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
-		return
+		return ratelimiter.Decision{}, false
+	}
+
+	if errors.Is(response.Error, ratelimiter.ErrCostExceedsLimit) || errors.Is(response.Error, ratelimiter.ErrInvalidCost) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"result": false, "error": response.Error.Error()})
+		return ratelimiter.Decision{}, false
+	}
+	if response.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("Error response: %s", response.Error.Error())})
+		return ratelimiter.Decision{}, false
 	}
 
-	_, ok := applyFuture.Response().(*distributed.ApplyResponse)
+	decision, ok := response.Data.(ratelimiter.Decision)
 	if !ok {
 		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": "Error response is not matched"})
-		return
+		return ratelimiter.Decision{}, false
 	}
-	h.RateLimiter.ResetQuota(clientID)
-	c.JSON(http.StatusOK, gin.H{"result": true})
+	return decision, true
+}
+
+// parseCost reads the optional cost query parameter, which defaults to one.
+func parseCost(c *gin.Context) (int, error) {
+	value := c.Query("cost")
+	if value == "" {
+		return 1, nil
+	}
+	cost, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if cost <= 0 {
+		return 0, ratelimiter.ErrInvalidCost
+	}
+	return cost, nil
+}
+
+func (h *APIHandler) now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+	return h.Clock.Now()
+}
+
+// applyCommand stamps cmd with the leader's clock and replicates it.
+// Increments go through the Batcher when there is one.
+func (h *APIHandler) applyCommand(cmd distributed.RateLimitCommand) (*distributed.ApplyResponse, error) {
+	cmd.Timestamp = h.now().UnixNano()
+	if cmd.Action == distributed.Increment && h.Batcher != nil {
+		return h.Batcher.Apply(cmd)
+	}
+
+	data, err := distributed.EncodeCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	applyFuture := h.RaftNode.Apply(data, 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		return nil, err
+	}
+
+	response, ok := applyFuture.Response().(*distributed.ApplyResponse)
+	if !ok {
+		return nil, errors.New("Error response is not matched")
+	}
+	return response, nil
+}
+
+func writeDecision(c *gin.Context, decision ratelimiter.Decision) {
+	if !decision.Allowed {
+		retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+	}
+	body := gin.H{
+		"result":          decision.Allowed,
+		"remaining_quota": decision.Remaining,
+		"retry_after_ms":  decision.RetryAfter.Milliseconds(),
+	}
+	if decision.Key != "" {
+		body["key"] = decision.Key
+	}
+	if decision.Rule != "" {
+		body["rule"] = decision.Rule
+	}
+	c.JSON(http.StatusOK, body)
 }
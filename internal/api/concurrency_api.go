@@ -0,0 +1,114 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhshih/ratelimiter/internal/distributed"
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+// AcquireConcurrencyHandler takes an in-flight slot for client_id. Passing the
+// lease_id of a held lease renews it instead.
+func (h *APIHandler) AcquireConcurrencyHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing client_id."})
+		return
+	}
+
+	leaseID := c.Query("lease_id")
+	if leaseID == "" {
+		var err error
+		if leaseID, err = newID(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+			return
+		}
+	}
+
+	cmd := distributed.RateLimitCommand{
+		Action:   distributed.Acquire,
+		ClientID: clientID,
+		LeaseID:  leaseID,
+	}
+
+	response, err := h.applyCommand(cmd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
+
+	if errors.Is(response.Error, ratelimiter.ErrNoConcurrencyLimit) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"result": false, "error": response.Error.Error()})
+		return
+	}
+	if response.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("Error response: %s", response.Error.Error())})
+		return
+	}
+
+	decision, ok := response.Data.(ratelimiter.Decision)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": "Error response is not matched"})
+		return
+	}
+
+	if !decision.Allowed {
+		writeDecision(c, decision)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"result":          true,
+		"lease_id":        leaseID,
+		"lease_ttl_ms":    h.RateLimiter.GetPolicy(clientID).LeaseTTLOrDefault().Milliseconds(),
+		"remaining_slots": decision.Remaining,
+	})
+}
+
+func (h *APIHandler) ReleaseConcurrencyHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing client_id."})
+		return
+	}
+	leaseID := c.Query("lease_id")
+	if leaseID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing lease_id."})
+		return
+	}
+
+	cmd := distributed.RateLimitCommand{
+		Action:   distributed.Release,
+		ClientID: clientID,
+		LeaseID:  leaseID,
+	}
+
+	response, err := h.applyCommand(cmd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
+
+	if errors.Is(response.Error, ratelimiter.ErrLeaseNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"result": false, "error": "Lease not found or already expired."})
+		return
+	}
+	if response.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("Error response: %s", response.Error.Error())})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": true})
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhshih/ratelimiter/internal/distributed"
+)
+
+// Consistency is how up to date a read has to be.
+type Consistency string
+
+const (
+	// ConsistencyStale reads the local state of any node, which may lag
+	// behind the leader.
+	ConsistencyStale Consistency = "stale"
+	// ConsistencyDefault reads the state of the leader after confirming its
+	// leadership with a quorum and waiting for its pending entries to apply.
+	ConsistencyDefault Consistency = "default"
+	// ConsistencyConsistent sends the read through the Raft log, so it is
+	// ordered with every write.
+	ConsistencyConsistent Consistency = "consistent"
+)
+
+var errInvalidConsistency = errors.New("invalid consistency")
+
+// barrierTimeout bounds how long a default read waits for the leader to apply
+// its pending entries.
+const barrierTimeout = 500 * time.Millisecond
+
+// parseConsistency reads the optional consistency query parameter.
+func parseConsistency(c *gin.Context) (Consistency, error) {
+	switch consistency := Consistency(c.DefaultQuery("consistency", string(ConsistencyDefault))); consistency {
+	case ConsistencyStale, ConsistencyDefault, ConsistencyConsistent:
+		return consistency, nil
+	}
+	return "", errInvalidConsistency
+}
+
+// checkQuota reads the remaining quota of clientID at the given consistency.
+// Apart from stale reads it has to run on the leader.
+func (h *APIHandler) checkQuota(clientID string, consistency Consistency) (int, error) {
+	switch consistency {
+	case ConsistencyDefault:
+		if err := h.RaftNode.VerifyLeader().Error(); err != nil {
+			return 0, err
+		}
+		// A new leader may not have applied the entries of its predecessor yet.
+		if h.RaftNode.AppliedIndex() < h.RaftNode.LastIndex() {
+			if err := h.RaftNode.Barrier(barrierTimeout).Error(); err != nil {
+				return 0, err
+			}
+		}
+	case ConsistencyConsistent:
+		response, err := h.applyCommand(distributed.RateLimitCommand{
+			Action:   distributed.Check,
+			ClientID: clientID,
+		})
+		if err != nil {
+			return 0, err
+		}
+		remaining, ok := response.Data.(int)
+		if !ok {
+			return 0, errors.New("Error response is not matched")
+		}
+		return remaining, nil
+	}
+	return h.RateLimiter.CheckQuota(clientID, h.now()), nil
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhshih/ratelimiter/internal/distributed"
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+// FeedbackHandler reports the outcome of a request to the protected backend so
+// that the limit of an adaptive client follows the backend's health.
+func (h *APIHandler) FeedbackHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing client_id."})
+		return
+	}
+
+	signal := ratelimiter.Signal(c.Query("signal"))
+	if signal != ratelimiter.SignalSuccess && signal != ratelimiter.SignalFailure {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Signal must be success or failure."})
+		return
+	}
+
+	var latency time.Duration
+	if value := c.Query("latency_ms"); value != "" {
+		ms, err := strconv.ParseFloat(value, 64)
+		if err != nil || ms < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid latency_ms."})
+			return
+		}
+		latency = time.Duration(ms * float64(time.Millisecond))
+	}
+
+	cmd := distributed.RateLimitCommand{
+		Action:   distributed.Feedback,
+		ClientID: clientID,
+		Signal:   signal,
+		Latency:  latency,
+	}
+
+	response, err := h.applyCommand(cmd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
+
+	if errors.Is(response.Error, ratelimiter.ErrNotAdaptive) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"result": false, "error": response.Error.Error()})
+		return
+	}
+	if response.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("Error response: %s", response.Error.Error())})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": true, "effective_limit": response.Data})
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/raft"
+)
+
+// ForwardedHeader marks a request that a follower has proxied to the leader.
+// Such a request is never forwarded again, so a stale view of the leadership
+// cannot make nodes bounce it between each other.
+const ForwardedHeader = "X-Ratelimiter-Forwarded"
+
+var ErrLeaderUnknown = errors.New("leader is unknown")
+
+// MemberDirectory resolves the HTTP API address of a cluster member by its
+// Raft server ID.
+type MemberDirectory interface {
+	APIAddr(nodeID string) (string, bool)
+}
+
+// ForwardToLeader is a middleware for the routes that apply Raft commands.
+// On a follower it proxies the request to the current leader, so clients can
+// send writes to any node.
+func (h *APIHandler) ForwardToLeader(c *gin.Context) {
+	if h.RaftNode.State() == raft.Leader {
+		c.Next()
+		return
+	}
+	h.forward(c)
+}
+
+// forward proxies the request to the current leader.
+func (h *APIHandler) forward(c *gin.Context) {
+	if c.GetHeader(ForwardedHeader) != "" {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"result": false, "error": "Node is no longer the leader."})
+		return
+	}
+
+	addr, err := h.leaderAPIAddr()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"result": false, "error": err.Error()})
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: addr})
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		c.JSON(http.StatusBadGateway, gin.H{"result": false, "error": "Forward to leader failed: " + err.Error()})
+	}
+	c.Request.Header.Set(ForwardedHeader, "true")
+	proxy.ServeHTTP(c.Writer, c.Request)
+	c.Abort()
+}
+
+func (h *APIHandler) leaderAPIAddr() (string, error) {
+	_, leaderID := h.RaftNode.LeaderWithID()
+	if leaderID == "" || h.Members == nil {
+		return "", ErrLeaderUnknown
+	}
+	addr, ok := h.Members.APIAddr(string(leaderID))
+	if !ok {
+		return "", ErrLeaderUnknown
+	}
+	return addr, nil
+}
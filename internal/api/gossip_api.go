@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+// GossipLimiter decides increments of policies with the gossip backend from
+// counters that nodes exchange without Raft.
+type GossipLimiter interface {
+	Allow(clientID string, policy ratelimiter.Policy, cost int, now time.Time) ratelimiter.Decision
+}
+
+// GossipQuota is a middleware for /rate/increment that answers increments of
+// clients whose policy uses the gossip backend on this node.
+func (h *APIHandler) GossipQuota(c *gin.Context) {
+	clientID := c.Query("client_id")
+	cost, err := parseCost(c)
+	if h.Gossip == nil || clientID == "" || err != nil {
+		c.Next()
+		return
+	}
+	policy := h.RateLimiter.GetPolicy(clientID)
+	if policy.Backend != ratelimiter.BackendGossip {
+		c.Next()
+		return
+	}
+
+	if cost > policy.Limit() {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"result": false, "error": ratelimiter.ErrCostExceedsLimit.Error()})
+		return
+	}
+	writeDecision(c, h.Gossip.Allow(clientID, policy, cost, h.now()))
+	c.Abort()
+}
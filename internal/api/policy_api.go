@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhshih/ratelimiter/internal/distributed"
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+type PolicyRequest struct {
+	ClientID string             `json:"client_id"`
+	Policy   ratelimiter.Policy `json:"policy"`
+}
+
+func (h *APIHandler) GetPolicyHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusOK, gin.H{"result": true, "policies": h.RateLimiter.Policies()})
+		return
+	}
+
+	policy, exists := h.RateLimiter.LookupPolicy(clientID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"result": false, "error": fmt.Sprintf("No policy for client_id %s.", clientID)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": true, "client_id": clientID, "policy": policy})
+}
+
+func (h *APIHandler) SetPolicyHandler(c *gin.Context) {
+	var req PolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid request payload."})
+		return
+	}
+	if req.ClientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing client_id."})
+		return
+	}
+	if err := req.Policy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
+
+	cmd := distributed.RateLimitCommand{
+		Action:   distributed.SetPolicy,
+		ClientID: req.ClientID,
+		Policy:   &req.Policy,
+	}
+
+	response, err := h.applyCommand(cmd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
+
+	if response.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("Error response: %s", response.Error.Error())})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": true, "client_id": req.ClientID, "policy": req.Policy})
+}
+
+func (h *APIHandler) DeletePolicyHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing client_id."})
+		return
+	}
+
+	cmd := distributed.RateLimitCommand{
+		Action:   distributed.DeletePolicy,
+		ClientID: clientID,
+	}
+
+	if _, err := h.applyCommand(cmd); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": true})
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/raft"
+
+	"github.com/zhshih/ratelimiter/internal/distributed"
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+// DefaultQuotaLeaseTTL is how long a node uses a quota slice when QuotaLeases
+// does not set a TTL.
+const DefaultQuotaLeaseTTL = time.Second
+
+// grantTimeout bounds how long a node waits for the leader to grant a slice.
+const grantTimeout = 500 * time.Millisecond
+
+var grantClient = &http.Client{Timeout: grantTimeout}
+
+// QuotaLeases lets a node admit increments locally from slices of the clients'
+// quotas granted by the leader, instead of replicating every increment. A
+// slice is used until it runs out or its TTL expires; its unused tokens, even
+// those of an expired slice, are given back when the next slice is requested.
+// Tokens a node holds are unavailable to the others, so the cluster admits at
+// most the configured quota but may admit less.
+type QuotaLeases struct {
+	// NodeID identifies this node's slices in the FSM.
+	NodeID string
+	// Size is the number of tokens a node asks for at once.
+	Size int
+	TTL  time.Duration
+
+	mu     sync.Mutex
+	slices map[string]*quotaSlice
+}
+
+// quotaSlice is the part of a client's quota this node holds. Only one new
+// slice is requested at a time, so that a node holds one per client.
+type quotaSlice struct {
+	mu     sync.Mutex
+	tokens int
+	expiry time.Time
+	// requesting is set while a new slice is requested from the leader.
+	requesting bool
+}
+
+func (q *QuotaLeases) slice(clientID string) *quotaSlice {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.slices == nil {
+		q.slices = make(map[string]*quotaSlice)
+	}
+	s, exists := q.slices[clientID]
+	if !exists {
+		s = &quotaSlice{}
+		q.slices[clientID] = s
+	}
+	return s
+}
+
+func (q *QuotaLeases) ttl() time.Duration {
+	if q.TTL > 0 {
+		return q.TTL
+	}
+	return DefaultQuotaLeaseTTL
+}
+
+// unused is the number of tokens left in the slice at now.
+func (s *quotaSlice) unused(now time.Time) int {
+	if !now.Before(s.expiry) {
+		return 0
+	}
+	return s.tokens
+}
+
+func (s *quotaSlice) take(cost int, now time.Time) bool {
+	if s.unused(now) < cost {
+		return false
+	}
+	s.tokens -= cost
+	return true
+}
+
+// LocalQuota is a middleware for /rate/increment that answers from this
+// node's slice of the client's quota when QuotaLeases is set. Increments with
+// a request_id, forwarded increments, increments of leaky_bucket policies and
+// increments for which no slice can be obtained, including those arriving
+// while a slice is being requested, fall through to the replicated path.
+func (h *APIHandler) LocalQuota(c *gin.Context) {
+	clientID := c.Query("client_id")
+	cost, err := parseCost(c)
+	if h.QuotaLeases == nil || clientID == "" || err != nil ||
+		c.Query("request_id") != "" || c.GetHeader(ForwardedHeader) != "" ||
+		!h.RateLimiter.CanGrantQuota(clientID) {
+		c.Next()
+		return
+	}
+
+	s := h.QuotaLeases.slice(clientID)
+	s.mu.Lock()
+	now := h.now()
+	if s.take(cost, now) {
+		remaining := s.tokens
+		s.mu.Unlock()
+		writeDecision(c, ratelimiter.Decision{Allowed: true, Remaining: remaining})
+		c.Abort()
+		return
+	}
+	if s.requesting {
+		s.mu.Unlock()
+		c.Next()
+		return
+	}
+	// The tokens left are given back with the request, so they must not be
+	// handed out meanwhile.
+	request := ratelimiter.GrantRequest{
+		NodeID:    h.QuotaLeases.NodeID,
+		Tokens:    max(cost, h.QuotaLeases.Size),
+		MinTokens: cost,
+		Returned:  s.tokens,
+		TTL:       h.QuotaLeases.ttl(),
+	}
+	s.tokens = 0
+	s.requesting = true
+	s.mu.Unlock()
+
+	decision, err := h.grantQuota(c.Request.Context(), clientID, request)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requesting = false
+	if err != nil {
+		s.tokens = request.Returned
+		c.Next()
+		return
+	}
+	if !decision.Allowed {
+		writeDecision(c, decision)
+		c.Abort()
+		return
+	}
+	s.tokens = decision.Remaining
+	s.expiry = now.Add(request.TTL)
+	s.take(cost, now)
+
+	writeDecision(c, ratelimiter.Decision{Allowed: true, Remaining: s.tokens})
+	c.Abort()
+}
+
+// GrantQuotaHandler grants a node a slice of the quota of client_id. It is
+// called by the LocalQuota middleware of other nodes.
+func (h *APIHandler) GrantQuotaHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	nodeID := c.Query("node_id")
+	if clientID == "" || nodeID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing client_id or node_id."})
+		return
+	}
+
+	request := ratelimiter.GrantRequest{NodeID: nodeID}
+	var err error
+	if request.Tokens, err = strconv.Atoi(c.Query("tokens")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid tokens."})
+		return
+	}
+	if request.MinTokens, err = strconv.Atoi(c.Query("min_tokens")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid min_tokens."})
+		return
+	}
+	if request.Returned, err = strconv.Atoi(c.DefaultQuery("returned", "0")); err != nil || request.Returned < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid returned."})
+		return
+	}
+	ttlMs, err := strconv.ParseInt(c.Query("ttl_ms"), 10, 64)
+	if err != nil || ttlMs <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid ttl_ms."})
+		return
+	}
+	request.TTL = time.Duration(ttlMs) * time.Millisecond
+
+	decision, err := h.applyGrant(clientID, request)
+	if errors.Is(err, ratelimiter.ErrCostExceedsLimit) || errors.Is(err, ratelimiter.ErrInvalidCost) ||
+		errors.Is(err, ratelimiter.ErrGrantNotSupported) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"result": false, "error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
+	writeGrant(c, decision)
+}
+
+// grantResponse is the body written by GrantQuotaHandler.
+type grantResponse struct {
+	Result       bool   `json:"result"`
+	Granted      int    `json:"granted"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
+	Error        string `json:"error"`
+}
+
+func writeGrant(c *gin.Context, decision ratelimiter.Decision) {
+	response := grantResponse{
+		Result:       decision.Allowed,
+		RetryAfterMs: decision.RetryAfter.Milliseconds(),
+	}
+	if decision.Allowed {
+		response.Granted = decision.Remaining
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// grantQuota obtains a slice from the leader, replicating the grant directly
+// if this node is the leader.
+func (h *APIHandler) grantQuota(ctx context.Context, clientID string, request ratelimiter.GrantRequest) (ratelimiter.Decision, error) {
+	if h.RaftNode.State() == raft.Leader {
+		return h.applyGrant(clientID, request)
+	}
+
+	addr, err := h.leaderAPIAddr()
+	if err != nil {
+		return ratelimiter.Decision{}, err
+	}
+	query := url.Values{
+		"client_id":  {clientID},
+		"node_id":    {request.NodeID},
+		"tokens":     {strconv.Itoa(request.Tokens)},
+		"min_tokens": {strconv.Itoa(request.MinTokens)},
+		"returned":   {strconv.Itoa(request.Returned)},
+		"ttl_ms":     {strconv.FormatInt(request.TTL.Milliseconds(), 10)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+"/quota/grant?"+query.Encode(), nil)
+	if err != nil {
+		return ratelimiter.Decision{}, err
+	}
+	req.Header.Set(ForwardedHeader, "true")
+	resp, err := grantClient.Do(req)
+	if err != nil {
+		return ratelimiter.Decision{}, err
+	}
+	defer resp.Body.Close()
+
+	var body grantResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return ratelimiter.Decision{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ratelimiter.Decision{}, errors.New(body.Error)
+	}
+	return ratelimiter.Decision{
+		Allowed:    body.Result,
+		Remaining:  body.Granted,
+		RetryAfter: time.Duration(body.RetryAfterMs) * time.Millisecond,
+	}, nil
+}
+
+func (h *APIHandler) applyGrant(clientID string, request ratelimiter.GrantRequest) (ratelimiter.Decision, error) {
+	response, err := h.applyCommand(distributed.RateLimitCommand{
+		Action:   distributed.GrantQuota,
+		ClientID: clientID,
+		Grant:    &request,
+	})
+	if err != nil {
+		return ratelimiter.Decision{}, err
+	}
+	if response.Error != nil {
+		return ratelimiter.Decision{}, response.Error
+	}
+	decision, ok := response.Data.(ratelimiter.Decision)
+	if !ok {
+		return ratelimiter.Decision{}, errors.New("Error response is not matched")
+	}
+	return decision, nil
+}
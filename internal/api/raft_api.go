@@ -3,6 +3,7 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hashicorp/raft"
@@ -10,6 +11,9 @@ import (
 
 type RaftHandler struct {
 	RaftNode *raft.Raft
+	// Shards holds the Raft node of every shard when the node runs several
+	// Raft groups. RaftNode is then the first of them.
+	Shards []*raft.Raft
 }
 
 type JoinRequest struct {
@@ -92,8 +96,19 @@ func (h *RaftHandler) RemoveRaftHandler(c *gin.Context) {
 		"stats":   h.RaftNode.Stats()})
 }
 
+// StatsRaftHandler reports the stats of the Raft group selected by the
+// optional shard parameter, which defaults to the first one.
 func (h *RaftHandler) StatsRaftHandler(c *gin.Context) {
+	raftNode := h.RaftNode
+	if value := c.Query("shard"); value != "" {
+		shard, err := strconv.Atoi(value)
+		if err != nil || shard < 0 || shard >= len(h.Shards) {
+			c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid shard."})
+			return
+		}
+		raftNode = h.Shards[shard]
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"result": true,
-		"stats":  h.RaftNode.Stats()})
+		"stats":  raftNode.Stats()})
 }
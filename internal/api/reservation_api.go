@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhshih/ratelimiter/internal/distributed"
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+// ReserveHandler books cost units of client_id ahead of time and tells the
+// caller how long to wait before acting on them. With max_wait_ms set nothing
+// is booked if the wait would be longer.
+func (h *APIHandler) ReserveHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing client_id."})
+		return
+	}
+
+	cost, err := parseCost(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid cost."})
+		return
+	}
+
+	var maxWait time.Duration
+	if value := c.Query("max_wait_ms"); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ms < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid max_wait_ms."})
+			return
+		}
+		maxWait = time.Duration(ms) * time.Millisecond
+	}
+
+	reservationID, err := newID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
+
+	cmd := distributed.RateLimitCommand{
+		Action:        distributed.Reserve,
+		ClientID:      clientID,
+		Cost:          cost,
+		ReservationID: reservationID,
+		MaxWait:       maxWait,
+	}
+
+	response, err := h.applyCommand(cmd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
+
+	if errors.Is(response.Error, ratelimiter.ErrReserveNotSupported) ||
+		errors.Is(response.Error, ratelimiter.ErrCostExceedsLimit) ||
+		errors.Is(response.Error, ratelimiter.ErrInvalidCost) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"result": false, "error": response.Error.Error()})
+		return
+	}
+	if response.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("Error response: %s", response.Error.Error())})
+		return
+	}
+
+	decision, ok := response.Data.(ratelimiter.Decision)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": "Error response is not matched"})
+		return
+	}
+
+	if !decision.Allowed {
+		writeDecision(c, decision)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"result":          true,
+		"reservation_id":  reservationID,
+		"delay_ms":        decision.Delay.Milliseconds(),
+		"remaining_quota": decision.Remaining,
+	})
+}
+
+// CancelReservationHandler gives back the units of a reservation that has not
+// been acted on yet.
+func (h *APIHandler) CancelReservationHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing client_id."})
+		return
+	}
+	reservationID := c.Query("reservation_id")
+	if reservationID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing reservation_id."})
+		return
+	}
+
+	cmd := distributed.RateLimitCommand{
+		Action:        distributed.CancelReservation,
+		ClientID:      clientID,
+		ReservationID: reservationID,
+	}
+
+	response, err := h.applyCommand(cmd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("%s", err)})
+		return
+	}
+
+	if errors.Is(response.Error, ratelimiter.ErrReservationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"result": false, "error": "Reservation not found or already due."})
+		return
+	}
+	if response.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"result": false, "error": fmt.Sprintf("Error response: %s", response.Error.Error())})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": true})
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhshih/ratelimiter/internal/distributed"
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+// shardContextKey caches the shard of a request for the rest of its handlers.
+const shardContextKey = "shard"
+
+// ShardRouter sends every request to the APIHandler of the Raft shard that
+// owns its client ID, see distributed.ShardFor.
+type ShardRouter struct {
+	Shards []*APIHandler
+}
+
+// Handle adapts a handler method such as (*APIHandler).IncrementQuotaHandler
+// to run on the shard of each request.
+func (r *ShardRouter) Handle(handler func(*APIHandler, *gin.Context)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handler(r.shardFor(c), c)
+	}
+}
+
+// GetPolicyHandler lists the policies of every shard when no client_id is
+// given and otherwise asks the shard of client_id.
+func (r *ShardRouter) GetPolicyHandler(c *gin.Context) {
+	if c.Query("client_id") != "" {
+		r.shardFor(c).GetPolicyHandler(c)
+		return
+	}
+
+	policies := make(map[string]ratelimiter.Policy)
+	for _, shard := range r.Shards {
+		for clientID, policy := range shard.RateLimiter.Policies() {
+			policies[clientID] = policy
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"result": true, "policies": policies})
+}
+
+func (r *ShardRouter) shardFor(c *gin.Context) *APIHandler {
+	if len(r.Shards) == 1 {
+		return r.Shards[0]
+	}
+	if shard, exists := c.Get(shardContextKey); exists {
+		return shard.(*APIHandler)
+	}
+	shard := r.Shards[distributed.ShardFor(requestClientID(c), len(r.Shards))]
+	c.Set(shardContextKey, shard)
+	return shard
+}
+
+// requestClientID returns the client ID of the request, taken from the
+// client_id query parameter or else from the JSON body. The body is restored
+// so that handlers can still read it.
+func requestClientID(c *gin.Context) string {
+	if clientID := c.Query("client_id"); clientID != "" {
+		return clientID
+	}
+	if c.Request.Body == nil {
+		return ""
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return ""
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req struct {
+		ClientID string `json:"client_id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	return req.ClientID
+}
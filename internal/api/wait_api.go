@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhshih/ratelimiter/internal/distributed"
+)
+
+const (
+	// DefaultWaitTimeout is how long WaitHandler blocks when the caller does
+	// not pass a timeout.
+	DefaultWaitTimeout = 30 * time.Second
+	// minWaitInterval keeps rejections without a usable retry time from
+	// turning the wait into a busy loop.
+	minWaitInterval = 10 * time.Millisecond
+)
+
+// WaitHandler blocks until cost units of client_id can be consumed, consumes
+// them and responds like an increment. Waiters of the same client on this node
+// are admitted in arrival order. If the quota cannot be had before timeout the
+// last rejection is returned, or 408 if the caller never got its turn.
+func (h *APIHandler) WaitHandler(c *gin.Context) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Missing client_id."})
+		return
+	}
+
+	cost, err := parseCost(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid cost."})
+		return
+	}
+
+	timeout := DefaultWaitTimeout
+	if value := c.Query("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"result": false, "error": "Invalid timeout."})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	turn := h.waiters.enqueue(clientID)
+	defer h.waiters.leave(clientID, turn)
+	select {
+	case <-turn:
+	case <-ctx.Done():
+		c.JSON(http.StatusRequestTimeout, gin.H{"result": false, "error": "Timed out waiting for earlier requests."})
+		return
+	}
+
+	cmd := distributed.RateLimitCommand{
+		Action:   distributed.Increment,
+		ClientID: clientID,
+		Cost:     cost,
+	}
+	deadline, _ := ctx.Deadline()
+	for {
+		decision, ok := h.applyIncrement(c, cmd)
+		if !ok {
+			return
+		}
+
+		wait := max(decision.RetryAfter, minWaitInterval)
+		if decision.Allowed || time.Until(deadline) < wait {
+			writeDecision(c, decision)
+			return
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			writeDecision(c, decision)
+			return
+		}
+	}
+}
+
+// waitQueue orders the callers blocked in WaitHandler by client so that they
+// are served first come, first served.
+type waitQueue struct {
+	mu     sync.Mutex
+	queues map[string][]chan struct{}
+}
+
+// enqueue appends a waiter for clientID. The returned channel is closed once
+// the waiter is at the head of the queue.
+func (q *waitQueue) enqueue(clientID string) chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.queues == nil {
+		q.queues = make(map[string][]chan struct{})
+	}
+	turn := make(chan struct{})
+	q.queues[clientID] = append(q.queues[clientID], turn)
+	if len(q.queues[clientID]) == 1 {
+		close(turn)
+	}
+	return turn
+}
+
+// leave removes a waiter from the queue of clientID and, if it was at the
+// head, hands the turn to the next one.
+func (q *waitQueue) leave(clientID string, turn chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queue := q.queues[clientID]
+	for i, waiter := range queue {
+		if waiter != turn {
+			continue
+		}
+		queue = append(queue[:i:i], queue[i+1:]...)
+		if len(queue) == 0 {
+			delete(q.queues, clientID)
+			return
+		}
+		q.queues[clientID] = queue
+		if i == 0 {
+			close(queue[0])
+		}
+		return
+	}
+}
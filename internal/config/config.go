@@ -1,9 +1,13 @@
 package config
 
+import "time"
+
 type ConfigRaft struct {
 	NodeID   string `json:"nodeID"`
 	BindAddr string `json:"bindAddr"`
 	DataDir  string `json:"dataDir"`
+	// Shards is the number of Raft groups the client IDs are spread over.
+	Shards int `json:"shards"`
 }
 
 type ConfigAPI struct {
@@ -16,3 +20,15 @@ type ConfigMembership struct {
 	Tags           map[string]string `json:"tags"`
 	StartJoinAddrs []string          `json:"startJoinAddrs"`
 }
+
+type ConfigRateLimiter struct {
+	Limit      int           `json:"limit"`
+	Window     time.Duration `json:"window"`
+	Burst      int           `json:"burst"`
+	RefillRate string        `json:"refillRate"`
+	// LeaseSize enables local quota leasing with slices of that many tokens.
+	LeaseSize int           `json:"leaseSize"`
+	LeaseTTL  time.Duration `json:"leaseTTL"`
+	// Backend is the backend of the default policy.
+	Backend string `json:"backend"`
+}
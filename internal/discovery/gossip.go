@@ -0,0 +1,240 @@
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+const (
+	// counterEvent is the name of the Serf user events carrying counters.
+	counterEvent = "ratelimit-counters"
+	// gossipInterval is how often a node sends the counters it has changed.
+	gossipInterval = 100 * time.Millisecond
+	// resyncIntervals is after how many gossip intervals a node sends all of
+	// its counters again, so that the others recover from lost events.
+	resyncIntervals = 10
+	// maxCounterPayload keeps counter events below Serf's default limit of 512
+	// bytes for the name and payload of a user event.
+	maxCounterPayload = 450
+	// maxCounterKey is the longest key sent as is. Longer keys are replaced by
+	// their hash so that an update fits into a counter event on its own.
+	maxCounterKey = 64
+)
+
+// counterUpdate is the count of one node for one key and window.
+type counterUpdate struct {
+	Key   string `json:"k"`
+	Start int64  `json:"s"`
+	End   int64  `json:"e"`
+	Node  string `json:"n"`
+	Count int    `json:"c"`
+}
+
+// gCounter is a grow-only counter of the units admitted for a key within one
+// window, with a slot per node. A node only increments its own slot and merges
+// the others by taking the maximum, so updates can be applied in any order and
+// any number of times and all nodes converge on the same total.
+type gCounter struct {
+	start  int64
+	end    int64
+	counts map[string]int
+}
+
+func (g *gCounter) total() int {
+	total := 0
+	for _, count := range g.counts {
+		total += count
+	}
+	return total
+}
+
+// gossipCounters holds the counters of the gossip backend on one node.
+type gossipCounters struct {
+	nodeID string
+
+	mu       sync.Mutex
+	counters map[string]*gCounter
+	// dirty holds the keys whose local slot changed since the last update.
+	dirty map[string]bool
+}
+
+func newGossipCounters(nodeID string) *gossipCounters {
+	return &gossipCounters{
+		nodeID:   nodeID,
+		counters: make(map[string]*gCounter),
+		dirty:    make(map[string]bool),
+	}
+}
+
+func counterKey(clientID, rule string) string {
+	key := clientID + "\x00" + rule
+	if len(key) > maxCounterKey {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+	return key
+}
+
+// counter returns the counter of key for the window around now. A counter of
+// an older window is replaced, while one of a newer window, started by a node
+// whose clock runs ahead, is kept.
+func (s *gossipCounters) counter(key string, window time.Duration, now time.Time) *gCounter {
+	start := now.UnixNano() - now.UnixNano()%int64(window)
+	counter, exists := s.counters[key]
+	if !exists || counter.start < start {
+		counter = &gCounter{start: start, end: start + int64(window), counts: make(map[string]int)}
+		s.counters[key] = counter
+	}
+	return counter
+}
+
+// allow admits cost units of clientID if every rule of policy has room for
+// them in the counts known to this node.
+func (s *gossipCounters) allow(clientID string, policy ratelimiter.Policy, cost int, now time.Time) ratelimiter.Decision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counters := make([]*gCounter, len(policy.Rules))
+	decision := ratelimiter.Decision{Allowed: true}
+	remaining := 0
+	for i, rule := range policy.Rules {
+		counters[i] = s.counter(counterKey(clientID, policy.RuleName(i)), rule.Window, now)
+		r := max(0, rule.Limit-counters[i].total())
+		if r < cost {
+			retryAfter := time.Duration(counters[i].end - now.UnixNano())
+			if decision.Allowed || retryAfter > decision.RetryAfter {
+				decision = ratelimiter.Decision{Allowed: false, Rule: policy.RuleName(i), RetryAfter: retryAfter}
+			}
+		}
+		if i == 0 || r < remaining {
+			remaining = r
+		}
+	}
+	if !decision.Allowed {
+		decision.Remaining = remaining
+		return decision
+	}
+
+	for i := range policy.Rules {
+		counters[i].counts[s.nodeID] += cost
+		s.dirty[counterKey(clientID, policy.RuleName(i))] = true
+	}
+	decision.Remaining = remaining - cost
+	return decision
+}
+
+// merge applies the count of another node.
+func (s *gossipCounters) merge(update counterUpdate) {
+	if update.Node == s.nodeID {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, exists := s.counters[update.Key]
+	if exists && counter.start > update.Start {
+		return
+	}
+	if !exists || counter.start < update.Start {
+		counter = &gCounter{start: update.Start, end: update.End, counts: make(map[string]int)}
+		s.counters[update.Key] = counter
+	}
+	counter.counts[update.Node] = max(counter.counts[update.Node], update.Count)
+}
+
+// drain returns the local slots changed since the last call, or with all set
+// every local slot of a window that has not ended, and forgets the counters of
+// windows that have ended.
+func (s *gossipCounters) drain(now time.Time, all bool) []counterUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updates := make([]counterUpdate, 0, len(s.dirty))
+	for key, counter := range s.counters {
+		ended := counter.end <= now.UnixNano()
+		if s.dirty[key] || (all && !ended && counter.counts[s.nodeID] > 0) {
+			updates = append(updates, counterUpdate{
+				Key:   key,
+				Start: counter.start,
+				End:   counter.end,
+				Node:  s.nodeID,
+				Count: counter.counts[s.nodeID],
+			})
+		}
+		if ended {
+			delete(s.counters, key)
+		}
+	}
+	clear(s.dirty)
+	return updates
+}
+
+// Allow decides an increment of a policy with the gossip backend from the
+// counters known to this node.
+func (m *DiscoveryAgent) Allow(clientID string, policy ratelimiter.Policy, cost int, now time.Time) ratelimiter.Decision {
+	return m.counters.allow(clientID, policy, cost, now)
+}
+
+// broadcastCounters periodically sends the changed local counters to the other
+// nodes, packed into as few user events as the size limit allows. Every
+// resyncIntervals it sends all of them, since Serf may drop events.
+func (m *DiscoveryAgent) broadcastCounters() {
+	ticker := time.NewTicker(gossipInterval)
+	defer ticker.Stop()
+
+	ticks := 0
+	for now := range ticker.C {
+		ticks++
+		var batch []json.RawMessage
+		size := 0
+		for _, update := range m.counters.drain(now, ticks%resyncIntervals == 0) {
+			data, err := json.Marshal(update)
+			if err != nil {
+				log.Printf("Encode counter of %q failed: %s", update.Key, err)
+				continue
+			}
+			if len(data)+2 > maxCounterPayload {
+				log.Printf("Drop counter of %q larger than a counter event", update.Key)
+				continue
+			}
+			if size+len(data)+1 > maxCounterPayload && len(batch) > 0 {
+				m.sendCounters(batch)
+				batch, size = nil, 0
+			}
+			batch = append(batch, data)
+			size += len(data) + 1
+		}
+		if len(batch) > 0 {
+			m.sendCounters(batch)
+		}
+	}
+}
+
+func (m *DiscoveryAgent) sendCounters(batch []json.RawMessage) {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		log.Printf("Encode counters failed: %s", err)
+		return
+	}
+	if err := m.serf.UserEvent(counterEvent, payload, false); err != nil {
+		log.Printf("Send counters failed: %s", err)
+	}
+}
+
+func (m *DiscoveryAgent) handleCounters(payload []byte) {
+	var updates []counterUpdate
+	if err := json.Unmarshal(payload, &updates); err != nil {
+		log.Printf("Decode counters failed: %s", err)
+		return
+	}
+	for _, update := range updates {
+		m.counters.merge(update)
+	}
+}
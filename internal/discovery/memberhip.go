@@ -16,17 +16,33 @@ type Handler interface {
 	Leave(name string) error
 }
 
+// RaftAddrTag is the Serf tag advertising the Raft address of shard.
+func RaftAddrTag(shard int) string {
+	if shard == 0 {
+		return "raft_addr"
+	}
+	return fmt.Sprintf("raft_addr_%d", shard)
+}
+
 type DiscoveryAgent struct {
-	Config  *config.ConfigMembership
-	handler Handler
-	serf    *serf.Serf
-	events  chan serf.Event
+	Config *config.ConfigMembership
+	// handlers holds a handler per Raft shard.
+	handlers []Handler
+	serf     *serf.Serf
+	events   chan serf.Event
+	// counters holds the counters of policies with the gossip backend.
+	counters *gossipCounters
 }
 
-func New(config *config.ConfigMembership, raftNode *raft.Raft) (*DiscoveryAgent, error) {
+// New joins the Serf cluster and adds the members that join it to every Raft
+// shard of raftNodes, in shard order.
+func New(config *config.ConfigMembership, raftNodes ...*raft.Raft) (*DiscoveryAgent, error) {
 	m := &DiscoveryAgent{
-		Config:  config,
-		handler: newMemberHandler(raftNode),
+		Config:   config,
+		counters: newGossipCounters(config.NodeName),
+	}
+	for _, raftNode := range raftNodes {
+		m.handlers = append(m.handlers, newMemberHandler(raftNode))
 	}
 	if err := m.setupSerf(); err != nil {
 		return nil, err
@@ -53,6 +69,7 @@ func (m *DiscoveryAgent) setupSerf() (err error) {
 	}
 
 	go m.eventHandler()
+	go m.broadcastCounters()
 	if m.Config.StartJoinAddrs != nil {
 		for {
 			_, err = m.serf.Join(m.Config.StartJoinAddrs, true)
@@ -76,6 +93,10 @@ func (m *DiscoveryAgent) eventHandler() {
 				}
 				m.handleJoin(member)
 			}
+		case serf.EventUser:
+			if event := e.(serf.UserEvent); event.Name == counterEvent {
+				m.handleCounters(event.Payload)
+			}
 		case serf.EventMemberLeave, serf.EventMemberFailed:
 			for _, member := range e.(serf.MemberEvent).Members {
 				if m.isLocal(member) {
@@ -89,20 +110,40 @@ func (m *DiscoveryAgent) eventHandler() {
 
 func (m *DiscoveryAgent) handleJoin(member serf.Member) {
 	log.Printf("member = %+v", member)
-	if err := m.handler.Join(
-		member.Name,
-		member.Tags["raft_addr"],
-	); err != nil {
-		m.logError(err, "failed to join", member)
+	for shard, handler := range m.handlers {
+		addr, ok := member.Tags[RaftAddrTag(shard)]
+		if !ok {
+			continue
+		}
+		if err := handler.Join(
+			member.Name,
+			addr,
+		); err != nil {
+			m.logError(err, "failed to join", member)
+		}
 	}
 }
 
 func (m *DiscoveryAgent) handleLeave(member serf.Member) {
-	if err := m.handler.Leave(
-		member.Name,
-	); err != nil {
-		m.logError(err, "failed to leave", member)
+	for _, handler := range m.handlers {
+		if err := handler.Leave(
+			member.Name,
+		); err != nil {
+			m.logError(err, "failed to leave", member)
+		}
+	}
+}
+
+// APIAddr returns the address of the HTTP API of the live member nodeID, as
+// advertised in its api_addr tag.
+func (m *DiscoveryAgent) APIAddr(nodeID string) (string, bool) {
+	for _, member := range m.serf.Members() {
+		if member.Name == nodeID && member.Status == serf.StatusAlive {
+			addr, ok := member.Tags["api_addr"]
+			return addr, ok && addr != ""
+		}
 	}
+	return "", false
 }
 
 func (m *DiscoveryAgent) isLocal(member serf.Member) bool {
@@ -0,0 +1,124 @@
+package distributed
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// DefaultMaxBatchSize is the number of commands a Batcher puts into one
+	// log entry at most.
+	DefaultMaxBatchSize = 256
+
+	batchApplyTimeout = 500 * time.Millisecond
+)
+
+var errResponseNotMatched = errors.New("Error response is not matched")
+
+// Batcher coalesces the commands applied concurrently on the leader into
+// Batch log entries, so that many decisions share the cost of one Raft commit.
+// Commands are applied in the order they were submitted.
+type Batcher struct {
+	raftNode     *raft.Raft
+	maxBatchSize int
+	requests     chan batchRequest
+}
+
+type batchRequest struct {
+	cmd    RateLimitCommand
+	result chan batchResult
+}
+
+type batchResult struct {
+	response *ApplyResponse
+	err      error
+}
+
+func NewBatcher(raftNode *raft.Raft, maxBatchSize int) *Batcher {
+	b := &Batcher{
+		raftNode:     raftNode,
+		maxBatchSize: maxBatchSize,
+		requests:     make(chan batchRequest, maxBatchSize),
+	}
+	go b.run()
+	return b
+}
+
+// Apply replicates cmd as part of the next batch and returns its response.
+func (b *Batcher) Apply(cmd RateLimitCommand) (*ApplyResponse, error) {
+	result := make(chan batchResult, 1)
+	b.requests <- batchRequest{cmd: cmd, result: result}
+	r := <-result
+	return r.response, r.err
+}
+
+// run applies one batch at a time. Commands submitted while a batch is being
+// committed queue up and form the next one, so batches grow with the load
+// without delaying commands when the cluster is idle.
+func (b *Batcher) run() {
+	for request := range b.requests {
+		batch := []batchRequest{request}
+	collect:
+		for len(batch) < b.maxBatchSize {
+			select {
+			case request := <-b.requests:
+				batch = append(batch, request)
+			default:
+				break collect
+			}
+		}
+		b.apply(batch)
+	}
+}
+
+func (b *Batcher) apply(batch []batchRequest) {
+	responses, err := b.applyBatch(batch)
+	for i, request := range batch {
+		if err != nil {
+			request.result <- batchResult{err: err}
+			continue
+		}
+		request.result <- batchResult{response: responses[i]}
+	}
+}
+
+func (b *Batcher) applyBatch(batch []batchRequest) ([]*ApplyResponse, error) {
+	cmd := batch[0].cmd
+	if len(batch) > 1 {
+		cmd = RateLimitCommand{Action: Batch, Commands: make([]RateLimitCommand, len(batch))}
+		for i, request := range batch {
+			cmd.Commands[i] = request.cmd
+		}
+	}
+
+	data, err := EncodeCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	applyFuture := b.raftNode.Apply(data, batchApplyTimeout)
+	if err := applyFuture.Error(); err != nil {
+		return nil, err
+	}
+
+	response, ok := applyFuture.Response().(*ApplyResponse)
+	if !ok {
+		return nil, errResponseNotMatched
+	}
+	if len(batch) == 1 {
+		return []*ApplyResponse{response}, nil
+	}
+
+	results, ok := response.Data.([]interface{})
+	if !ok || len(results) != len(batch) {
+		return nil, errResponseNotMatched
+	}
+	responses := make([]*ApplyResponse, len(results))
+	for i, result := range results {
+		if responses[i], ok = result.(*ApplyResponse); !ok {
+			return nil, errResponseNotMatched
+		}
+	}
+	return responses, nil
+}
@@ -0,0 +1,53 @@
+package distributed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+)
+
+// Log entries and snapshots start with a format byte so that their encoding
+// can change without breaking the replay of existing logs. Entries written
+// before the format byte existed are JSON objects and start with '{'.
+const (
+	formatMsgpack byte = 1
+
+	formatJSON byte = '{'
+)
+
+var msgpackHandle = &codec.MsgpackHandle{}
+
+// EncodeCommand encodes cmd as the data of a Raft log entry.
+func EncodeCommand(cmd RateLimitCommand) ([]byte, error) {
+	return encode(cmd)
+}
+
+func decodeCommand(data []byte) (RateLimitCommand, error) {
+	var cmd RateLimitCommand
+	err := decode(data, &cmd)
+	return cmd, err
+}
+
+func encode(v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{formatMsgpack})
+	if err := codec.NewEncoder(buf, msgpackHandle).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("decode: empty data")
+	}
+	switch data[0] {
+	case formatMsgpack:
+		return codec.NewDecoderBytes(data[1:], msgpackHandle).Decode(v)
+	case formatJSON:
+		return json.Unmarshal(data, v)
+	default:
+		return fmt.Errorf("decode: unknown format %d", data[0])
+	}
+}
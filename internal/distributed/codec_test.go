@@ -0,0 +1,168 @@
+package distributed
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+func TestDecodeJSONCommand(t *testing.T) {
+	// A log entry as written before the format byte existed, with a policy
+	// in the single-rule layout of the time.
+	data := []byte(`{"action":3,"client_id":"alice","reset_time":0,` +
+		`"policy":{"limit":10,"window":"1s","burst":5,"refill_rate":2},"timestamp":1700000000000000000}`)
+
+	cmd, err := decodeCommand(data)
+	if err != nil {
+		t.Fatalf("decodeCommand: %s", err)
+	}
+	want := RateLimitCommand{
+		Action:    SetPolicy,
+		ClientID:  "alice",
+		Timestamp: 1700000000000000000,
+		Policy: &ratelimiter.Policy{Rules: []ratelimiter.Rule{{
+			Limit:      10,
+			Window:     time.Second,
+			Burst:      5,
+			RefillRate: ratelimiter.PerSecond(2),
+		}}},
+	}
+	if !reflect.DeepEqual(cmd, want) {
+		t.Errorf("decodeCommand = %+v, want %+v", cmd, want)
+	}
+}
+
+func TestDecodeJSONSnapshot(t *testing.T) {
+	// A snapshot as written before the format byte existed, with a client in
+	// the single-limiter layout of the time.
+	data := []byte(`{"version":1,"last_timestamp":1700000000000000000,"state":{` +
+		`"policies":{"alice":{"rules":[{"limit":10,"window":"1s","burst":0,"refill_rate":0}]}},` +
+		`"clients":{"alice":{"algorithm":"fixed_window","limit":10,"window":1000000000,` +
+		`"count":3,"reset_time":1700000001000000000,"refill_rate":0}}}}`)
+
+	snapshot, err := decodeSnapshot(data)
+	if err != nil {
+		t.Fatalf("decodeSnapshot: %s", err)
+	}
+	want := snapshotData{
+		Version:       1,
+		LastTimestamp: 1700000000000000000,
+		State: &ratelimiter.State{
+			Policies: map[string]ratelimiter.Policy{
+				"alice": {Rules: []ratelimiter.Rule{{Limit: 10, Window: time.Second, RefillRate: ratelimiter.PerSecond(0)}}},
+			},
+			Clients: map[string]ratelimiter.ClientState{
+				"alice": {Rules: []ratelimiter.LimiterState{{
+					Algorithm:  ratelimiter.FixedWindow,
+					Limit:      10,
+					Window:     int64(time.Second),
+					Count:      3,
+					ResetTime:  1700000001000000000,
+					RefillRate: ratelimiter.PerSecond(0),
+				}}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(snapshot, want) {
+		t.Errorf("decodeSnapshot = %+v, want %+v", *snapshot.State, *want.State)
+	}
+}
+
+// Every map holds several entries, since the msgpack decoder reuses one value
+// for all entries of a map.
+func TestSnapshotRoundTrip(t *testing.T) {
+	want := snapshotData{
+		Version:       snapshotVersion,
+		LastTimestamp: 1700000000000000000,
+		State: &ratelimiter.State{
+			DefaultPolicy: &ratelimiter.Policy{Rules: []ratelimiter.Rule{{Limit: 20, Window: time.Minute}}},
+			Policies: map[string]ratelimiter.Policy{
+				"alice": {Rules: []ratelimiter.Rule{
+					{Name: "second", Limit: 10, Window: time.Second, Burst: 5, RefillRate: ratelimiter.PerSecond(2)},
+					{Name: "day", Algorithm: ratelimiter.SlidingWindowLog, Limit: 500, Window: 24 * time.Hour},
+				}},
+				"bob": {
+					Rules:    []ratelimiter.Rule{{Algorithm: ratelimiter.GCRA, Limit: 7, Window: time.Minute}},
+					Adaptive: &ratelimiter.Adaptive{MinLimit: 2},
+				},
+				"carol": {Rules: []ratelimiter.Rule{{Limit: 3, Window: time.Hour}}, MaxConcurrent: 4},
+			},
+			Clients: map[string]ratelimiter.ClientState{
+				"alice": {Rules: []ratelimiter.LimiterState{
+					{
+						Name:       "second",
+						Algorithm:  ratelimiter.FixedWindow,
+						Limit:      10,
+						Window:     int64(time.Second),
+						Count:      3,
+						ResetTime:  1700000001000000000,
+						Tokens:     2.5,
+						MaxTokens:  5,
+						RefillRate: ratelimiter.PerSecond(2),
+						LastRefill: 1700000000000000000,
+					},
+					{
+						Name:       "day",
+						Algorithm:  ratelimiter.SlidingWindowLog,
+						Limit:      500,
+						Window:     int64(24 * time.Hour),
+						Timestamps: []int64{1699999999000000000, 1700000000000000000},
+					},
+				}},
+				"bob": {Rules: []ratelimiter.LimiterState{{
+					Algorithm: ratelimiter.GCRA,
+					Limit:     7,
+					Window:    int64(time.Minute),
+					Burst:     7,
+					TAT:       1700000002000000000,
+				}}},
+				"carol": {Rules: []ratelimiter.LimiterState{{
+					Algorithm: ratelimiter.FixedWindow,
+					Limit:     3,
+					Window:    int64(time.Hour),
+					Count:     1,
+					ResetTime: 1700003600000000000,
+				}}},
+			},
+			Leases: map[string]map[string]int64{
+				"carol": {"lease-1": 1700000005000000000, "lease-2": 1700000006000000000},
+			},
+			AdaptiveLimits: map[string]int{"alice": 8, "bob": 5},
+			Reservations: map[string]map[string]ratelimiter.Reservation{
+				"alice": {"r-1": {Keys: []string{"alice"}, Cost: 2, At: 1700000000500000000}},
+				"bob":   {"r-2": {Keys: []string{"bob"}, Cost: 1, At: 1700000001000000000}},
+			},
+			QuotaGrants: map[string]map[string]ratelimiter.QuotaGrant{
+				"alice": {
+					"node-1": {Keys: []string{"alice"}, Tokens: 4, Expiry: 1700000002000000000},
+					"node-2": {Keys: []string{"alice"}, Tokens: 1, Expiry: 1700000003000000000},
+				},
+				"org/bob": {"node-1": {Keys: []string{"org", "org/bob"}, Tokens: 2, Expiry: 1700000004000000000}},
+			},
+		},
+		Dedupe: []dedupeEntry{
+			{ClientID: "alice", Key: "request-1", Timestamp: 1700000000000000000, Decision: ratelimiter.Decision{Allowed: true, Remaining: 7}},
+			{ClientID: "bob", Key: "request-2", Timestamp: 1700000000000000001, Decision: ratelimiter.Decision{Allowed: false, Rule: "0"}},
+		},
+	}
+
+	data, err := encode(want)
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+	if data[0] != formatMsgpack {
+		t.Fatalf("format byte = %d, want %d", data[0], formatMsgpack)
+	}
+	got, err := decodeSnapshot(data)
+	if err != nil {
+		t.Fatalf("decodeSnapshot: %s", err)
+	}
+	if !reflect.DeepEqual(got.State, want.State) {
+		t.Errorf("state after round trip = %+v, want %+v", *got.State, *want.State)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
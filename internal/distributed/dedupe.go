@@ -0,0 +1,96 @@
+package distributed
+
+import (
+	"time"
+
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+const (
+	// dedupeCapacity bounds the number of idempotency keys the FSM remembers.
+	dedupeCapacity = 10000
+	// dedupeTTL is how long a retried increment is recognised.
+	dedupeTTL = 10 * time.Minute
+)
+
+// dedupeEntry is the decision of an admitted increment, remembered under its
+// idempotency key.
+type dedupeEntry struct {
+	ClientID  string               `json:"client_id"`
+	Key       string               `json:"key"`
+	Timestamp int64                `json:"timestamp"`
+	Decision  ratelimiter.Decision `json:"decision"`
+}
+
+// dedupeTable remembers the decisions of admitted increments so that a retry
+// with the same idempotency key returns the original decision instead of
+// consuming again. Entries are evicted oldest first once they are older than
+// dedupeTTL or the table is full, which only depends on the log and therefore
+// happens identically on every replica.
+type dedupeTable struct {
+	entries map[string]dedupeEntry
+	// order holds the keys of entries in the order they were applied.
+	order []string
+}
+
+func newDedupeTable() *dedupeTable {
+	return &dedupeTable{entries: make(map[string]dedupeEntry)}
+}
+
+func dedupeKey(clientID, key string) string {
+	return clientID + "\x00" + key
+}
+
+func (t *dedupeTable) lookup(clientID, key string, now time.Time) (ratelimiter.Decision, bool) {
+	t.expire(now)
+	entry, exists := t.entries[dedupeKey(clientID, key)]
+	return entry.Decision, exists
+}
+
+func (t *dedupeTable) remember(clientID, key string, now time.Time, decision ratelimiter.Decision) {
+	k := dedupeKey(clientID, key)
+	if _, exists := t.entries[k]; exists {
+		return
+	}
+	t.entries[k] = dedupeEntry{
+		ClientID:  clientID,
+		Key:       key,
+		Timestamp: now.UnixNano(),
+		Decision:  decision,
+	}
+	t.order = append(t.order, k)
+	for len(t.order) > dedupeCapacity {
+		t.evictOldest()
+	}
+}
+
+func (t *dedupeTable) expire(now time.Time) {
+	cutoff := now.Add(-dedupeTTL).UnixNano()
+	for len(t.order) > 0 && t.entries[t.order[0]].Timestamp <= cutoff {
+		t.evictOldest()
+	}
+}
+
+func (t *dedupeTable) evictOldest() {
+	delete(t.entries, t.order[0])
+	t.order = t.order[1:]
+}
+
+// snapshot returns the entries oldest first.
+func (t *dedupeTable) snapshot() []dedupeEntry {
+	entries := make([]dedupeEntry, len(t.order))
+	for i, k := range t.order {
+		entries[i] = t.entries[k]
+	}
+	return entries
+}
+
+func restoreDedupeTable(entries []dedupeEntry) *dedupeTable {
+	t := newDedupeTable()
+	for _, entry := range entries {
+		k := dedupeKey(entry.ClientID, entry.Key)
+		t.entries[k] = entry
+		t.order = append(t.order, k)
+	}
+	return t
+}
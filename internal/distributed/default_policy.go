@@ -0,0 +1,50 @@
+package distributed
+
+import (
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+// defaultPolicyTimeout bounds how long a new leader waits to catch up with the
+// log and to replicate its default policy.
+const defaultPolicyTimeout = 5 * time.Second
+
+// ReplicateDefaultPolicy replicates policy as the default policy of the group
+// whenever this node becomes its leader, so that every replica applies the log
+// with the same default rather than with its own configuration. It blocks and
+// is meant to run in its own goroutine.
+func ReplicateDefaultPolicy(raftNode *raft.Raft, limiter *ratelimiter.RateLimiter, policy ratelimiter.Policy) {
+	for isLeader := range raftNode.LeaderCh() {
+		if !isLeader {
+			continue
+		}
+		// Compare against the default once the entries of earlier leaders
+		// have been applied.
+		if err := raftNode.Barrier(defaultPolicyTimeout).Error(); err != nil {
+			log.Printf("Wait for the log before replicating the default policy failed: %s", err)
+			continue
+		}
+		if reflect.DeepEqual(limiter.GetDefaultPolicy(), policy) {
+			continue
+		}
+
+		data, err := EncodeCommand(RateLimitCommand{Action: SetDefaultPolicy, Policy: &policy})
+		if err != nil {
+			log.Printf("Encode default policy failed: %s", err)
+			continue
+		}
+		applyFuture := raftNode.Apply(data, defaultPolicyTimeout)
+		if err := applyFuture.Error(); err != nil {
+			log.Printf("Replicate default policy failed: %s", err)
+			continue
+		}
+		if response, ok := applyFuture.Response().(*ApplyResponse); ok && response.Error != nil {
+			log.Printf("Replicate default policy failed: %s", response.Error)
+		}
+	}
+}
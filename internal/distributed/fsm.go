@@ -1,10 +1,12 @@
 package distributed
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/raft"
 
@@ -17,12 +19,48 @@ const (
 	Check ActionType = iota
 	Increment
 	Reset
+	SetPolicy
+	DeletePolicy
+	Acquire
+	Release
+	Feedback
+	Reserve
+	CancelReservation
+	Batch
+	GrantQuota
+	SetDefaultPolicy
 )
 
+var ErrNestedBatch = errors.New("batches cannot be nested")
+
 type RateLimitCommand struct {
-	Action    ActionType `json:"action"`
-	ClientID  string     `json:"client_id"`
-	ResetTime int64      `json:"reset_time"`
+	Action    ActionType          `json:"action"`
+	ClientID  string              `json:"client_id"`
+	ResetTime int64               `json:"reset_time"`
+	Policy    *ratelimiter.Policy `json:"policy,omitempty"`
+	// Timestamp is stamped by the leader in Unix nanoseconds so every replica
+	// evaluates the command against the same point in time.
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// Cost is the number of units an increment consumes. Zero means one.
+	Cost int `json:"cost,omitempty"`
+	// LeaseID identifies a concurrency lease. It is chosen by the leader so
+	// that every replica records the same lease.
+	LeaseID string `json:"lease_id,omitempty"`
+	// Signal and Latency carry backend feedback for adaptive policies.
+	Signal  ratelimiter.Signal `json:"signal,omitempty"`
+	Latency time.Duration      `json:"latency,omitempty"`
+	// ReservationID identifies a reservation and is chosen by the leader.
+	// MaxWait bounds the delay a reservation may book, zero meaning no bound.
+	ReservationID string        `json:"reservation_id,omitempty"`
+	MaxWait       time.Duration `json:"max_wait,omitempty"`
+	// IdempotencyKey is supplied by the client. An admitted increment retried
+	// with the same key returns the original decision without consuming again.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Commands are the commands of a Batch, applied in order. The response of
+	// a batch holds their responses in the same order.
+	Commands []RateLimitCommand `json:"commands,omitempty"`
+	// Grant asks for a slice of the client's quota on behalf of a node.
+	Grant *ratelimiter.GrantRequest `json:"grant,omitempty"`
 }
 
 type ApplyResponse struct {
@@ -33,11 +71,16 @@ type ApplyResponse struct {
 type RateLimiterFSM struct {
 	mu          sync.Mutex
 	rateLimiter *ratelimiter.RateLimiter
+	// lastTimestamp is the logical clock of the FSM. It never goes backwards,
+	// even if a new leader's wall clock lags behind the previous one.
+	lastTimestamp int64
+	dedupe        *dedupeTable
 }
 
 func NewRateLimiterFSM(limiter *ratelimiter.RateLimiter) *RateLimiterFSM {
 	return &RateLimiterFSM{
 		rateLimiter: limiter,
+		dedupe:      newDedupeTable(),
 	}
 }
 
@@ -45,31 +88,112 @@ func (fsm *RateLimiterFSM) Apply(raftLog *raft.Log) interface{} {
 	fsm.mu.Lock()
 	defer fsm.mu.Unlock()
 
-	var cmd RateLimitCommand
-	if err := json.Unmarshal(raftLog.Data, &cmd); err != nil {
+	cmd, err := decodeCommand(raftLog.Data)
+	if err != nil {
 		return err
 	}
+	return fsm.apply(cmd)
+}
 
+func (fsm *RateLimiterFSM) apply(cmd RateLimitCommand) interface{} {
+	now := fsm.advanceClock(cmd.Timestamp)
 	switch cmd.Action {
 	case Check:
-		remaining := fsm.rateLimiter.CheckQuota(cmd.ClientID)
+		remaining := fsm.rateLimiter.CheckQuota(cmd.ClientID, now)
 		return &ApplyResponse{Error: nil, Data: remaining}
 	case Increment:
-		allowed := fsm.rateLimiter.AllowRequest(cmd.ClientID)
-		return &ApplyResponse{Error: nil, Data: allowed}
+		return fsm.applyIncrement(cmd, now)
 	case Reset:
-		fsm.rateLimiter.ResetQuota(cmd.ClientID)
+		fsm.rateLimiter.ResetQuota(cmd.ClientID, now)
+		return &ApplyResponse{Error: nil}
+	case SetPolicy:
+		if cmd.Policy == nil {
+			return &ApplyResponse{Error: ratelimiter.ErrInvalidPolicy}
+		}
+		err := fsm.rateLimiter.SetPolicy(cmd.ClientID, *cmd.Policy)
+		return &ApplyResponse{Error: err}
+	case SetDefaultPolicy:
+		if cmd.Policy == nil {
+			return &ApplyResponse{Error: ratelimiter.ErrInvalidPolicy}
+		}
+		err := fsm.rateLimiter.SetDefaultPolicy(*cmd.Policy)
+		return &ApplyResponse{Error: err}
+	case DeletePolicy:
+		fsm.rateLimiter.DeletePolicy(cmd.ClientID)
 		return &ApplyResponse{Error: nil}
+	case Acquire:
+		decision, err := fsm.rateLimiter.Acquire(cmd.ClientID, cmd.LeaseID, now)
+		return &ApplyResponse{Error: err, Data: decision}
+	case Release:
+		err := fsm.rateLimiter.Release(cmd.ClientID, cmd.LeaseID, now)
+		return &ApplyResponse{Error: err}
+	case Feedback:
+		limit, err := fsm.rateLimiter.Feedback(cmd.ClientID, cmd.Signal, cmd.Latency)
+		return &ApplyResponse{Error: err, Data: limit}
+	case Reserve:
+		decision, err := fsm.rateLimiter.Reserve(cmd.ClientID, cmd.ReservationID, max(1, cmd.Cost), cmd.MaxWait, now)
+		return &ApplyResponse{Error: err, Data: decision}
+	case CancelReservation:
+		err := fsm.rateLimiter.Cancel(cmd.ClientID, cmd.ReservationID, now)
+		return &ApplyResponse{Error: err}
+	case Batch:
+		results := make([]interface{}, len(cmd.Commands))
+		for i, command := range cmd.Commands {
+			if command.Action == Batch {
+				results[i] = &ApplyResponse{Error: ErrNestedBatch}
+				continue
+			}
+			results[i] = fsm.apply(command)
+		}
+		return &ApplyResponse{Error: nil, Data: results}
+	case GrantQuota:
+		if cmd.Grant == nil {
+			return &ApplyResponse{Error: ratelimiter.ErrInvalidCost}
+		}
+		decision, err := fsm.rateLimiter.GrantQuota(cmd.ClientID, *cmd.Grant, now)
+		return &ApplyResponse{Error: err, Data: decision}
 	}
 	return nil
 }
 
+// applyIncrement consumes the cost of cmd unless an increment with the same
+// idempotency key has already been admitted. Rejections are not remembered
+// since they did not consume anything.
+func (fsm *RateLimiterFSM) applyIncrement(cmd RateLimitCommand, now time.Time) *ApplyResponse {
+	if cmd.IdempotencyKey != "" {
+		if decision, exists := fsm.dedupe.lookup(cmd.ClientID, cmd.IdempotencyKey, now); exists {
+			return &ApplyResponse{Error: nil, Data: decision}
+		}
+	}
+
+	decision, err := fsm.rateLimiter.AllowRequest(cmd.ClientID, max(1, cmd.Cost), now)
+	if err == nil && decision.Allowed && cmd.IdempotencyKey != "" {
+		fsm.dedupe.remember(cmd.ClientID, cmd.IdempotencyKey, now, decision)
+	}
+	return &ApplyResponse{Error: err, Data: decision}
+}
+
+// advanceClock moves the logical clock forward to timestamp and returns it.
+// Entries without a timestamp are evaluated at the last known time.
+func (fsm *RateLimiterFSM) advanceClock(timestamp int64) time.Time {
+	if timestamp > fsm.lastTimestamp {
+		fsm.lastTimestamp = timestamp
+	}
+	return time.Unix(0, fsm.lastTimestamp)
+}
+
 func (fsm *RateLimiterFSM) Snapshot() (raft.FSMSnapshot, error) {
 	fsm.mu.Lock()
 	defer fsm.mu.Unlock()
 
-	rateLimitInfo := fsm.rateLimiter.GetRateLimitInfo()
-	return &RateLimiterSnapshot{data: *rateLimitInfo}, nil
+	return &RateLimiterSnapshot{
+		data: snapshotData{
+			Version:       snapshotVersion,
+			LastTimestamp: fsm.lastTimestamp,
+			State:         fsm.rateLimiter.Snapshot(),
+			Dedupe:        fsm.dedupe.snapshot(),
+		},
+	}, nil
 }
 
 func (fsm *RateLimiterFSM) Restore(rc io.ReadCloser) error {
@@ -82,42 +206,41 @@ func (fsm *RateLimiterFSM) Restore(rc io.ReadCloser) error {
 		}
 	}()
 
-	log.Println("Read all message from snapshot")
-	var totalRestored int
-
-	decoder := json.NewDecoder(rc)
-	for decoder.More() {
-		payload := &RateLimitCommand{}
-		err := decoder.Decode(payload)
-		if err != nil {
-			log.Print("Decode failed:", err)
-			return err
-		}
-
-		ActionType := payload.Action
-		log.Printf("ActionType = %d", ActionType)
-		clientID := payload.ClientID
-		log.Printf("clientID = %s", clientID)
-		resetTime := payload.ResetTime
-		log.Printf("resetTime = %d", resetTime)
-		switch ActionType {
-		case Increment:
-			allowed := fsm.rateLimiter.AllowRequest(clientID)
-			if !allowed {
-				log.Printf("clientID %s is not allowed to request", clientID)
-			}
-		case Reset:
-			fsm.rateLimiter.ResetQuota(clientID)
-		}
-		totalRestored++
+	log.Println("Read rate limiter state from snapshot")
+	raw, err := io.ReadAll(rc)
+	if err != nil {
+		log.Print("Read snapshot failed:", err)
+		return err
 	}
-
-	_, err := decoder.Token()
+	data, err := decodeSnapshot(raw)
 	if err != nil {
 		log.Print("Decode failed:", err)
 		return err
 	}
 
-	log.Printf("Restore %d messages successfully in snapshot", totalRestored)
+	switch data.Version {
+	case snapshotVersion, 2:
+		// Version 2 lacks the default policy, so the built-in one is used
+		// until the leader replicates its own.
+	case 1:
+		// Fields added since are optional. Clients whose state lacks the
+		// window are dropped by the rate limiter.
+	case 0:
+		// Snapshots written before versioning never contained usable state.
+		log.Println("Ignore unversioned snapshot and start from an empty state")
+		data.State = &ratelimiter.State{}
+	default:
+		return fmt.Errorf("unsupported snapshot version %d", data.Version)
+	}
+	if data.State == nil {
+		data.State = &ratelimiter.State{}
+	}
+
+	fsm.rateLimiter.Restore(data.State)
+	fsm.lastTimestamp = data.LastTimestamp
+	fsm.dedupe = restoreDedupeTable(data.Dedupe)
+
+	log.Printf("Restore %d clients and %d policies successfully in snapshot",
+		len(data.State.Clients), len(data.State.Policies))
 	return nil
 }
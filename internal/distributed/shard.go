@@ -0,0 +1,55 @@
+package distributed
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zhshih/ratelimiter/internal/ratelimiter"
+)
+
+// ShardPortStride is the distance between the Raft ports of consecutive
+// shards of a node, so that the ports of neighbouring nodes do not collide.
+const ShardPortStride = 1000
+
+// ShardFor returns the shard owning clientID out of shards. Only the top-level
+// segment of a hierarchical client ID is hashed, so that a client and all of
+// its ancestors live in the same shard and can be charged atomically.
+func ShardFor(clientID string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	root, _, _ := strings.Cut(clientID, ratelimiter.KeySeparator)
+	h := fnv.New32a()
+	h.Write([]byte(root))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// ShardBindAddr returns the Raft address of shard given the address of shard 0.
+func ShardBindAddr(addr string, shard int) (string, error) {
+	if shard == 0 {
+		return addr, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", fmt.Errorf("invalid port in %s: %w", addr, err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p+shard*ShardPortStride)), nil
+}
+
+// ShardDataDir returns the directory of the log store and snapshots of shard.
+// Shard 0 uses dataDir itself, which is where a node with a single Raft group
+// keeps its data.
+func ShardDataDir(dataDir string, shard int) string {
+	if shard == 0 {
+		return dataDir
+	}
+	return filepath.Join(dataDir, fmt.Sprintf("shard-%d", shard))
+}
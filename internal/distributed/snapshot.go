@@ -1,19 +1,96 @@
 package distributed
 
 import (
-	"encoding/json"
-
 	"github.com/hashicorp/raft"
 
 	"github.com/zhshih/ratelimiter/internal/ratelimiter"
 )
 
+// snapshotVersion is bumped whenever the layout of snapshotData changes.
+const snapshotVersion = 3
+
+type snapshotData struct {
+	Version       int                `json:"version"`
+	LastTimestamp int64              `json:"last_timestamp"`
+	State         *ratelimiter.State `json:"state"`
+	Dedupe        []dedupeEntry      `json:"dedupe,omitempty"`
+}
+
+// snapshotWire is what snapshotData is decoded into. The msgpack decoder reuses
+// one value for all entries of a map, so map values holding slices or pointers
+// would all share those of the last entry. Pointer values are allocated for
+// every entry instead.
+type snapshotWire struct {
+	Version       int           `json:"version"`
+	LastTimestamp int64         `json:"last_timestamp"`
+	State         *stateWire    `json:"state"`
+	Dedupe        []dedupeEntry `json:"dedupe,omitempty"`
+}
+
+// stateWire mirrors ratelimiter.State with pointer map values.
+type stateWire struct {
+	DefaultPolicy  *ratelimiter.Policy                            `json:"default_policy,omitempty"`
+	Policies       map[string]*ratelimiter.Policy                 `json:"policies"`
+	Clients        map[string]*ratelimiter.ClientState            `json:"clients"`
+	Leases         map[string]map[string]int64                    `json:"leases,omitempty"`
+	AdaptiveLimits map[string]int                                 `json:"adaptive_limits,omitempty"`
+	Reservations   map[string]map[string]*ratelimiter.Reservation `json:"reservations,omitempty"`
+	QuotaGrants    map[string]map[string]*ratelimiter.QuotaGrant  `json:"quota_grants,omitempty"`
+}
+
+func decodeSnapshot(raw []byte) (snapshotData, error) {
+	var wire snapshotWire
+	if err := decode(raw, &wire); err != nil {
+		return snapshotData{}, err
+	}
+	data := snapshotData{
+		Version:       wire.Version,
+		LastTimestamp: wire.LastTimestamp,
+		Dedupe:        wire.Dedupe,
+	}
+	if wire.State == nil {
+		return data, nil
+	}
+
+	data.State = &ratelimiter.State{
+		DefaultPolicy:  wire.State.DefaultPolicy,
+		Policies:       derefValues(wire.State.Policies),
+		Clients:        derefValues(wire.State.Clients),
+		Leases:         wire.State.Leases,
+		AdaptiveLimits: wire.State.AdaptiveLimits,
+	}
+	if wire.State.Reservations != nil {
+		data.State.Reservations = make(map[string]map[string]ratelimiter.Reservation, len(wire.State.Reservations))
+		for clientID, reservations := range wire.State.Reservations {
+			data.State.Reservations[clientID] = derefValues(reservations)
+		}
+	}
+	if wire.State.QuotaGrants != nil {
+		data.State.QuotaGrants = make(map[string]map[string]ratelimiter.QuotaGrant, len(wire.State.QuotaGrants))
+		for clientID, grants := range wire.State.QuotaGrants {
+			data.State.QuotaGrants[clientID] = derefValues(grants)
+		}
+	}
+	return data, nil
+}
+
+func derefValues[V any](m map[string]*V) map[string]V {
+	if m == nil {
+		return nil
+	}
+	values := make(map[string]V, len(m))
+	for key, value := range m {
+		values[key] = *value
+	}
+	return values
+}
+
 type RateLimiterSnapshot struct {
-	data ratelimiter.RateLimitInfo
+	data snapshotData
 }
 
 func (s *RateLimiterSnapshot) Persist(sink raft.SnapshotSink) error {
-	data, err := json.Marshal(s.data)
+	data, err := encode(s.data)
 	if err != nil {
 		sink.Cancel()
 		return err
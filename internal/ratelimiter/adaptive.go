@@ -0,0 +1,136 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotAdaptive = errors.New("policy is not adaptive")
+
+// Signal is feedback from the protected backend about a request.
+type Signal string
+
+const (
+	SignalSuccess Signal = "success"
+	SignalFailure Signal = "failure"
+)
+
+// Adaptive makes the limit of the first rule of a policy follow the health of
+// the backend with additive increase and multiplicative decrease (AIMD): every
+// success raises the limit by Increase up to the configured rule limit, and
+// every failure multiplies it by DecreaseFactor down to MinLimit.
+type Adaptive struct {
+	MinLimit       int     `json:"min_limit"`
+	Increase       int     `json:"increase,omitempty"`
+	DecreaseFactor float64 `json:"decrease_factor,omitempty"`
+	// LatencyThreshold turns successes slower than it into failures. Zero
+	// ignores latency.
+	LatencyThreshold time.Duration `json:"latency_threshold,omitempty"`
+}
+
+// adaptiveJSON shadows LatencyThreshold so it is encoded as a string.
+type adaptiveJSON struct {
+	adaptiveAlias
+	LatencyThreshold jsonDuration `json:"latency_threshold,omitempty"`
+}
+
+type adaptiveAlias Adaptive
+
+func (a Adaptive) MarshalJSON() ([]byte, error) {
+	return json.Marshal(adaptiveJSON{
+		adaptiveAlias:    adaptiveAlias(a),
+		LatencyThreshold: jsonDuration(a.LatencyThreshold),
+	})
+}
+
+func (a *Adaptive) UnmarshalJSON(data []byte) error {
+	var aux adaptiveJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*a = Adaptive(aux.adaptiveAlias)
+	a.LatencyThreshold = time.Duration(aux.LatencyThreshold)
+	return nil
+}
+
+func (a *Adaptive) validate(maxLimit int) error {
+	if a.MinLimit <= 0 || a.MinLimit > maxLimit || a.Increase < 0 || a.LatencyThreshold < 0 {
+		return fmt.Errorf("%w: invalid adaptive limits", ErrInvalidPolicy)
+	}
+	if a.DecreaseFactor < 0 || a.DecreaseFactor >= 1 {
+		return fmt.Errorf("%w: decrease_factor must be in [0, 1)", ErrInvalidPolicy)
+	}
+	return nil
+}
+
+func (a *Adaptive) increase() int {
+	if a.Increase == 0 {
+		return 1
+	}
+	return a.Increase
+}
+
+func (a *Adaptive) decreaseFactor() float64 {
+	if a.DecreaseFactor == 0 {
+		return 0.5
+	}
+	return a.DecreaseFactor
+}
+
+// Feedback adjusts the effective limit of clientID according to signal and
+// the latency the backend observed, and returns the new limit.
+func (rl *RateLimiter) Feedback(clientID string, signal Signal, latency time.Duration) (int, error) {
+	if signal != SignalSuccess && signal != SignalFailure {
+		return 0, fmt.Errorf("unknown signal %q", signal)
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	policy := rl.policyFor(clientID)
+	adaptive := policy.Adaptive
+	if adaptive == nil {
+		return 0, ErrNotAdaptive
+	}
+
+	maxLimit := policy.Rules[0].Limit
+	limit, exists := rl.adaptiveLimits[clientID]
+	if !exists {
+		limit = maxLimit
+	}
+
+	failed := signal == SignalFailure ||
+		(adaptive.LatencyThreshold > 0 && latency > adaptive.LatencyThreshold)
+	if failed {
+		limit = max(adaptive.MinLimit, int(float64(limit)*adaptive.decreaseFactor()))
+	} else {
+		limit = min(maxLimit, limit+adaptive.increase())
+	}
+
+	if limit == maxLimit {
+		delete(rl.adaptiveLimits, clientID)
+	} else {
+		rl.adaptiveLimits[clientID] = limit
+	}
+	if clientRateLimit, exists := rl.limits.Info[clientID]; exists && len(clientRateLimit.rules) > 0 {
+		clientRateLimit.rules[0].limiter.setLimit(limit)
+	}
+	return limit, nil
+}
+
+// effectivePolicy is the policy of clientID with the adaptive limit applied.
+func (rl *RateLimiter) effectivePolicy(clientID string) Policy {
+	policy := rl.policyFor(clientID)
+	limit, exists := rl.adaptiveLimits[clientID]
+	if !exists {
+		return policy
+	}
+
+	rules := make([]Rule, len(policy.Rules))
+	copy(rules, policy.Rules)
+	rules[0].Limit = limit
+	policy.Rules = rules
+	return policy
+}
@@ -0,0 +1,83 @@
+package ratelimiter
+
+import "time"
+
+// Algorithm selects how a policy decides whether a request is allowed.
+type Algorithm string
+
+const (
+	// FixedWindow is a per-window quota combined with a token bucket. It is
+	// the default when a policy does not name an algorithm.
+	FixedWindow Algorithm = "fixed_window"
+	// SlidingWindowLog records the time of every request in the window.
+	SlidingWindowLog Algorithm = "sliding_window_log"
+	// SlidingWindowCounter weights the previous window's count to approximate
+	// a sliding window in constant memory.
+	SlidingWindowCounter Algorithm = "sliding_window_counter"
+	// GCRA spaces requests evenly by tracking a theoretical arrival time.
+	GCRA Algorithm = "gcra"
+	// LeakyBucket queues requests and delays them to leave at a constant rate.
+	LeakyBucket Algorithm = "leaky_bucket"
+)
+
+// Decision is the outcome of a request. RetryAfter is how long a rejected
+// caller has to wait until the next request would be allowed, and Delay is how
+// long an allowed caller of a shaping algorithm should hold the request. Key
+// and Rule name the client ID and rule that rejected the request.
+type Decision struct {
+	Allowed    bool          `json:"allowed"`
+	Key        string        `json:"key,omitempty"`
+	Rule       string        `json:"rule,omitempty"`
+	Remaining  int           `json:"remaining"`
+	RetryAfter time.Duration `json:"retry_after"`
+	Delay      time.Duration `json:"delay,omitempty"`
+}
+
+// limiter is the per-client state of an algorithm. Implementations take the
+// time as an argument and never read the wall clock.
+type limiter interface {
+	// allow admits a request of the given cost all-or-nothing.
+	allow(now time.Time, cost int) Decision
+	// check returns the decision allow would make without changing any state,
+	// so that several limiters can be checked before any of them is charged.
+	check(now time.Time, cost int) Decision
+	remaining(now time.Time) int
+	// capacity is the largest cost that can ever be admitted at once.
+	capacity() int
+	// cancel gives back cost units that were consumed but not used, as far as
+	// they still count against the limit.
+	cancel(now time.Time, cost int)
+	// setLimit changes the limit while keeping the consumed quota.
+	setLimit(limit int)
+	state() LimiterState
+}
+
+func newLimiter(rule Rule, now time.Time) limiter {
+	switch rule.Algorithm {
+	case SlidingWindowLog:
+		return newSlidingWindowLog(rule)
+	case SlidingWindowCounter:
+		return newSlidingWindowCounter(rule, now)
+	case GCRA:
+		return newGCRA(rule, now)
+	case LeakyBucket:
+		return newLeakyBucket(rule, now)
+	default:
+		return newFixedWindow(rule, now)
+	}
+}
+
+func restoreLimiter(state LimiterState) limiter {
+	switch state.Algorithm {
+	case SlidingWindowLog:
+		return restoreSlidingWindowLog(state)
+	case SlidingWindowCounter:
+		return restoreSlidingWindowCounter(state)
+	case GCRA:
+		return restoreGCRA(state)
+	case LeakyBucket:
+		return restoreLeakyBucket(state)
+	default:
+		return restoreFixedWindow(state)
+	}
+}
@@ -0,0 +1,74 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+	// Embed the time zone database so that every replica resolves the same
+	// zones, whatever is installed on its host.
+	_ "time/tzdata"
+)
+
+// Calendar aligns the window of a fixed_window rule to calendar boundaries in
+// a time zone instead of starting it at the first request.
+type Calendar string
+
+const (
+	CalendarDay   Calendar = "day"
+	CalendarWeek  Calendar = "week"
+	CalendarMonth Calendar = "month"
+	CalendarYear  Calendar = "year"
+)
+
+// windowPeriod computes when a fixed window ends.
+type windowPeriod struct {
+	window   time.Duration
+	calendar Calendar
+	timeZone string
+	location *time.Location
+}
+
+func newWindowPeriod(window time.Duration, calendar Calendar, timeZone string) (windowPeriod, error) {
+	period := windowPeriod{window: window, calendar: calendar, timeZone: timeZone}
+	if calendar == "" {
+		return period, nil
+	}
+
+	switch calendar {
+	case CalendarDay, CalendarWeek, CalendarMonth, CalendarYear:
+	default:
+		return period, fmt.Errorf("%w: unknown calendar %q", ErrInvalidPolicy, calendar)
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return period, fmt.Errorf("%w: %s", ErrInvalidPolicy, err)
+	}
+	period.location = location
+	return period, nil
+}
+
+// end returns when the window containing now ends. Calendar windows end at the
+// next boundary, e.g. the next midnight in the time zone, so every replica
+// computes the same reset for the same command.
+func (p windowPeriod) end(now time.Time) time.Time {
+	if p.calendar == "" {
+		return now.Add(p.window)
+	}
+
+	local := now.In(p.location)
+	year, month, day := local.Date()
+	switch p.calendar {
+	case CalendarDay:
+		return time.Date(year, month, day+1, 0, 0, 0, 0, p.location)
+	case CalendarWeek:
+		// Weeks start on Monday.
+		daysUntilMonday := (8 - int(local.Weekday())) % 7
+		if daysUntilMonday == 0 {
+			daysUntilMonday = 7
+		}
+		return time.Date(year, month, day+daysUntilMonday, 0, 0, 0, 0, p.location)
+	case CalendarMonth:
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, p.location)
+	default:
+		return time.Date(year+1, time.January, 1, 0, 0, 0, 0, p.location)
+	}
+}
@@ -0,0 +1,20 @@
+package ratelimiter
+
+import "time"
+
+// Clock supplies the current time. Replicated code paths must not read the
+// wall clock directly, so callers pass the time explicitly and only the edges
+// of the system (such as the leader stamping a command) consult a Clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func SystemClock() Clock {
+	return systemClock{}
+}
@@ -0,0 +1,84 @@
+package ratelimiter
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNoConcurrencyLimit = errors.New("policy has no concurrency limit")
+	ErrLeaseNotFound      = errors.New("lease not found")
+)
+
+// Acquire takes one of the MaxConcurrent in-flight slots of clientID under
+// leaseID. The lease expires after the policy's LeaseTTL so that crashed
+// callers cannot leak slots; acquiring an existing lease again renews it.
+func (rl *RateLimiter) Acquire(clientID, leaseID string, now time.Time) (Decision, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	policy := rl.policyFor(clientID)
+	if policy.MaxConcurrent == 0 {
+		return Decision{}, ErrNoConcurrencyLimit
+	}
+
+	leases := rl.expireLeases(clientID, now)
+	_, renewing := leases[leaseID]
+	if !renewing && len(leases) >= policy.MaxConcurrent {
+		return Decision{
+			Allowed:    false,
+			RetryAfter: time.Duration(earliestExpiry(leases) - now.UnixNano()),
+		}, nil
+	}
+
+	if leases == nil {
+		leases = make(map[string]int64)
+		rl.leases[clientID] = leases
+	}
+	leases[leaseID] = now.Add(policy.LeaseTTLOrDefault()).UnixNano()
+	return Decision{Allowed: true, Remaining: policy.MaxConcurrent - len(leases)}, nil
+}
+
+// Release gives the slot held by leaseID back to clientID.
+func (rl *RateLimiter) Release(clientID, leaseID string, now time.Time) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	leases := rl.expireLeases(clientID, now)
+	if _, exists := leases[leaseID]; !exists {
+		return ErrLeaseNotFound
+	}
+	delete(leases, leaseID)
+	if len(leases) == 0 {
+		delete(rl.leases, clientID)
+	}
+	return nil
+}
+
+// expireLeases drops the leases of clientID that expired at or before now.
+func (rl *RateLimiter) expireLeases(clientID string, now time.Time) map[string]int64 {
+	leases, exists := rl.leases[clientID]
+	if !exists {
+		return nil
+	}
+	for leaseID, expiry := range leases {
+		if expiry <= now.UnixNano() {
+			delete(leases, leaseID)
+		}
+	}
+	if len(leases) == 0 {
+		delete(rl.leases, clientID)
+		return nil
+	}
+	return leases
+}
+
+func earliestExpiry(leases map[string]int64) int64 {
+	earliest := int64(0)
+	for _, expiry := range leases {
+		if earliest == 0 || expiry < earliest {
+			earliest = expiry
+		}
+	}
+	return earliest
+}
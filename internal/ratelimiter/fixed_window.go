@@ -0,0 +1,188 @@
+package ratelimiter
+
+import (
+	"log"
+	"time"
+)
+
+type clientQuota struct {
+	limit     int
+	count     int
+	resetTime time.Time
+}
+
+// fixedWindow combines a per-window request quota with an optional token
+// bucket that bounds bursts inside the window.
+type fixedWindow struct {
+	quota       *clientQuota
+	tokenBucket *TokenBucket
+	period      windowPeriod
+}
+
+func newFixedWindow(rule Rule, now time.Time) *fixedWindow {
+	// The rule has been validated, so the time zone is known to load.
+	period, _ := newWindowPeriod(rule.Window, rule.Calendar, rule.TimeZone)
+	fw := &fixedWindow{
+		quota: &clientQuota{
+			limit:     rule.Limit,
+			count:     0,
+			resetTime: period.end(now),
+		},
+		period: period,
+	}
+	if rule.Burst > 0 {
+		fw.tokenBucket = NewTokenBucket(rule.Burst, rule.RefillRate, now)
+	}
+	return fw
+}
+
+func (fw *fixedWindow) allow(now time.Time, cost int) Decision {
+	decision := fw.check(now, cost)
+	if !decision.Allowed {
+		return decision
+	}
+	fw.rollWindow(now)
+	if fw.tokenBucket != nil {
+		fw.tokenBucket.tryConsume(cost, now)
+	}
+	fw.quota.count += cost
+	return decision
+}
+
+func (fw *fixedWindow) check(now time.Time, cost int) Decision {
+	count, resetTime := fw.quota.count, fw.quota.resetTime
+	rolled := !now.Before(resetTime)
+	if rolled {
+		count, resetTime = 0, fw.period.end(now)
+	}
+
+	remaining := max(0, fw.quota.limit-count)
+	if count+cost > fw.quota.limit {
+		return Decision{Allowed: false, Remaining: remaining, RetryAfter: resetTime.Sub(now)}
+	}
+	if fw.tokenBucket != nil {
+		bucket := *fw.tokenBucket
+		if rolled {
+			bucket = *NewTokenBucket(bucket.maxTokens, bucket.refillRate, now)
+		}
+		bucket.refill(now)
+		if bucket.tokens < float64(cost) {
+			retryAfter := bucket.nextRefill(cost, now)
+			if retryAfter <= 0 || now.Add(retryAfter).After(resetTime) {
+				retryAfter = resetTime.Sub(now)
+			}
+			return Decision{Allowed: false, Remaining: remaining, RetryAfter: retryAfter}
+		}
+	}
+	return Decision{Allowed: true, Remaining: remaining - cost}
+}
+
+// reserve books cost units of the current window. The token bucket may go
+// into debt, in which case the caller has to wait until it has refilled, but
+// not past the end of the window since the bucket starts over then.
+func (fw *fixedWindow) reserve(now time.Time, cost int) (time.Duration, bool) {
+	fw.rollWindow(now)
+
+	untilReset := fw.quota.resetTime.Sub(now)
+	if fw.quota.count+cost > fw.quota.limit {
+		return untilReset, false
+	}
+	delay := time.Duration(0)
+	if fw.tokenBucket != nil {
+		fw.tokenBucket.refill(now)
+		delay = fw.tokenBucket.nextRefill(cost, now)
+		if (delay <= 0 && fw.tokenBucket.tokens < float64(cost)) || delay >= untilReset {
+			return untilReset, false
+		}
+		fw.tokenBucket.tokens -= float64(cost)
+	}
+	fw.quota.count += cost
+	return delay, true
+}
+
+func (fw *fixedWindow) cancel(now time.Time, cost int) {
+	if !now.Before(fw.quota.resetTime) {
+		return
+	}
+	fw.quota.count = max(0, fw.quota.count-cost)
+	if fw.tokenBucket != nil {
+		fw.tokenBucket.refill(now)
+		fw.tokenBucket.tokens = min(float64(fw.tokenBucket.maxTokens), fw.tokenBucket.tokens+float64(cost))
+	}
+}
+
+// rollWindow starts a new window once the current one has ended.
+func (fw *fixedWindow) rollWindow(now time.Time) {
+	if now.Before(fw.quota.resetTime) {
+		return
+	}
+	fw.quota.count = 0
+	fw.quota.resetTime = fw.period.end(now)
+	if fw.tokenBucket != nil {
+		fw.tokenBucket = NewTokenBucket(fw.tokenBucket.maxTokens, fw.tokenBucket.refillRate, now)
+	}
+}
+
+func (fw *fixedWindow) remaining(now time.Time) int {
+	if !now.Before(fw.quota.resetTime) {
+		return fw.quota.limit
+	}
+	return max(0, fw.quota.limit-fw.quota.count)
+}
+
+func (fw *fixedWindow) setLimit(limit int) {
+	fw.quota.limit = limit
+}
+
+func (fw *fixedWindow) capacity() int {
+	if fw.tokenBucket == nil {
+		return fw.quota.limit
+	}
+	return min(fw.quota.limit, fw.tokenBucket.maxTokens)
+}
+
+func (fw *fixedWindow) state() LimiterState {
+	state := LimiterState{
+		Algorithm: FixedWindow,
+		Limit:     fw.quota.limit,
+		Count:     fw.quota.count,
+		ResetTime: fw.quota.resetTime.UnixNano(),
+		Window:    int64(fw.period.window),
+		Calendar:  fw.period.calendar,
+		TimeZone:  fw.period.timeZone,
+	}
+	if fw.tokenBucket != nil {
+		state.Tokens = fw.tokenBucket.tokens
+		state.MaxTokens = fw.tokenBucket.maxTokens
+		state.RefillRate = fw.tokenBucket.refillRate
+		state.LastRefill = fw.tokenBucket.lastRefillTime.UnixNano()
+	}
+	return state
+}
+
+func restoreFixedWindow(state LimiterState) *fixedWindow {
+	period, err := newWindowPeriod(time.Duration(state.Window), state.Calendar, state.TimeZone)
+	if err != nil {
+		// Keep the calendar boundaries, if only in UTC, rather than leaving
+		// the client without a window.
+		log.Printf("Restore calendar window failed, fall back to UTC: %s", err)
+		period.location = time.UTC
+	}
+	fw := &fixedWindow{
+		quota: &clientQuota{
+			limit:     state.Limit,
+			count:     state.Count,
+			resetTime: time.Unix(0, state.ResetTime),
+		},
+		period: period,
+	}
+	if state.MaxTokens > 0 {
+		fw.tokenBucket = &TokenBucket{
+			tokens:         state.Tokens,
+			maxTokens:      state.MaxTokens,
+			refillRate:     state.RefillRate,
+			lastRefillTime: time.Unix(0, state.LastRefill),
+		}
+	}
+	return fw
+}
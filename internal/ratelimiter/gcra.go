@@ -0,0 +1,106 @@
+package ratelimiter
+
+import "time"
+
+// gcra implements the generic cell rate algorithm. Its only state is the
+// theoretical arrival time (TAT) of the next request: requests are admitted
+// at one per emission interval, with up to burst requests let in early.
+type gcra struct {
+	limit  int
+	window time.Duration
+	burst  int
+	tat    int64
+}
+
+func newGCRA(rule Rule, now time.Time) *gcra {
+	burst := rule.Burst
+	if burst == 0 {
+		burst = rule.Limit
+	}
+	return &gcra{
+		limit:  rule.Limit,
+		window: rule.Window,
+		burst:  burst,
+		tat:    now.UnixNano(),
+	}
+}
+
+// emissionInterval is the spacing between requests at the sustained rate.
+func (g *gcra) emissionInterval() int64 {
+	return max(1, int64(g.window)/int64(g.limit))
+}
+
+// tolerance is how far ahead of now the TAT may run before rejecting.
+func (g *gcra) tolerance() int64 {
+	return g.emissionInterval() * int64(g.burst)
+}
+
+// allow advances the TAT by one emission interval per unit of cost.
+func (g *gcra) allow(now time.Time, cost int) Decision {
+	decision := g.check(now, cost)
+	if decision.Allowed {
+		g.tat = max(g.tat, now.UnixNano()) + g.emissionInterval()*int64(cost)
+	}
+	return decision
+}
+
+func (g *gcra) check(now time.Time, cost int) Decision {
+	nowNano := now.UnixNano()
+	newTAT := max(g.tat, nowNano) + g.emissionInterval()*int64(cost)
+	allowAt := newTAT - g.tolerance()
+	if nowNano < allowAt {
+		return Decision{
+			Allowed:    false,
+			Remaining:  g.remaining(now),
+			RetryAfter: time.Duration(allowAt - nowNano),
+		}
+	}
+
+	next := *g
+	next.tat = newTAT
+	return Decision{Allowed: true, Remaining: next.remaining(now)}
+}
+
+// reserve advances the TAT unconditionally and returns how long until the
+// request would have been admitted.
+func (g *gcra) reserve(now time.Time, cost int) (time.Duration, bool) {
+	nowNano := now.UnixNano()
+	g.tat = max(g.tat, nowNano) + g.emissionInterval()*int64(cost)
+	return time.Duration(max(0, g.tat-g.tolerance()-nowNano)), true
+}
+
+func (g *gcra) cancel(now time.Time, cost int) {
+	g.tat = max(now.UnixNano(), g.tat-g.emissionInterval()*int64(cost))
+}
+
+func (g *gcra) setLimit(limit int) {
+	g.limit = limit
+}
+
+func (g *gcra) capacity() int {
+	return g.burst
+}
+
+func (g *gcra) remaining(now time.Time) int {
+	backlog := max(g.tat, now.UnixNano()) - now.UnixNano()
+	return int(max(0, (g.tolerance()-backlog)/g.emissionInterval()))
+}
+
+func (g *gcra) state() LimiterState {
+	return LimiterState{
+		Algorithm: GCRA,
+		Limit:     g.limit,
+		Window:    int64(g.window),
+		Burst:     g.burst,
+		TAT:       g.tat,
+	}
+}
+
+func restoreGCRA(state LimiterState) *gcra {
+	return &gcra{
+		limit:  state.Limit,
+		window: time.Duration(state.Window),
+		burst:  state.Burst,
+		tat:    state.TAT,
+	}
+}
@@ -0,0 +1,51 @@
+package ratelimiter
+
+import (
+	"strings"
+	"time"
+)
+
+// KeySeparator splits hierarchical client IDs such as "org/user/key".
+const KeySeparator = "/"
+
+// enforcedKeys returns the keys a request of clientID is charged to, from the
+// outermost ancestor to clientID itself. An ancestor is only enforced when it
+// has a policy of its own, so that plain client IDs containing the separator
+// are not suddenly capped by the default policy of a made-up parent.
+func (rl *RateLimiter) enforcedKeys(clientID string) []string {
+	parts := strings.Split(clientID, KeySeparator)
+	keys := make([]string, 0, len(parts))
+	for i := 1; i < len(parts); i++ {
+		ancestor := strings.Join(parts[:i], KeySeparator)
+		if _, exists := rl.policies[ancestor]; exists {
+			keys = append(keys, ancestor)
+		}
+	}
+	return append(keys, clientID)
+}
+
+// allowHierarchy admits a request only if every key admits it, in which case
+// the request is consumed from all of them. All keys are checked before any is
+// charged so that a rejection further down the hierarchy leaves the ancestors
+// untouched.
+func (rl *RateLimiter) allowHierarchy(keys []string, clients []*ClientRateLimit, now time.Time, cost int) Decision {
+	decision := Decision{Allowed: true}
+	for i, client := range clients {
+		keyDecision := client.check(now, cost)
+		keyDecision.Key = keys[i]
+		decision = combineDecisions(decision, keyDecision)
+	}
+
+	if decision.Allowed {
+		for _, client := range clients {
+			client.allow(now, cost)
+		}
+	}
+
+	for i, client := range clients {
+		if r := client.remaining(now); i == 0 || r < decision.Remaining {
+			decision.Remaining = r
+		}
+	}
+	return decision
+}
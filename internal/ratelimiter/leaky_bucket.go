@@ -0,0 +1,108 @@
+package ratelimiter
+
+import "time"
+
+// leakyBucket shapes traffic instead of only admitting it: requests join a
+// queue that drains at limit per window, and each admitted request is told how
+// long to delay so that they leave at a constant rate. Requests beyond a queue
+// depth of maxDepth are rejected.
+type leakyBucket struct {
+	limit    int
+	window   time.Duration
+	maxDepth int
+	// next is when the queue has drained enough for a new request to leave.
+	next int64
+}
+
+func newLeakyBucket(rule Rule, now time.Time) *leakyBucket {
+	return &leakyBucket{
+		limit:    rule.Limit,
+		window:   rule.Window,
+		maxDepth: rule.Burst,
+		next:     now.UnixNano(),
+	}
+}
+
+func (lb *leakyBucket) interval() int64 {
+	return max(1, int64(lb.window)/int64(lb.limit))
+}
+
+// allow queues cost requests, which then take cost slots of the queue.
+func (lb *leakyBucket) allow(now time.Time, cost int) Decision {
+	decision := lb.check(now, cost)
+	if decision.Allowed {
+		lb.next = now.Add(decision.Delay).UnixNano() + lb.interval()*int64(cost)
+	}
+	return decision
+}
+
+func (lb *leakyBucket) check(now time.Time, cost int) Decision {
+	nowNano := now.UnixNano()
+	delay := max(lb.next, nowNano) - nowNano
+	depth := lb.depth(delay)
+	if depth+cost > lb.maxDepth {
+		// Wait until enough of the queue has leaked for cost more slots.
+		retryAfter := delay - int64(lb.maxDepth-cost)*lb.interval()
+		return Decision{
+			Allowed:    false,
+			Remaining:  max(0, lb.maxDepth-depth),
+			RetryAfter: time.Duration(retryAfter),
+		}
+	}
+	return Decision{
+		Allowed:   true,
+		Remaining: lb.maxDepth - depth - cost,
+		Delay:     time.Duration(delay),
+	}
+}
+
+// reserve queues cost requests regardless of the queue depth, leaving it to
+// the caller to bound the delay.
+func (lb *leakyBucket) reserve(now time.Time, cost int) (time.Duration, bool) {
+	nowNano := now.UnixNano()
+	delay := max(lb.next, nowNano) - nowNano
+	lb.next = nowNano + delay + lb.interval()*int64(cost)
+	return time.Duration(delay), true
+}
+
+func (lb *leakyBucket) cancel(now time.Time, cost int) {
+	lb.next = max(now.UnixNano(), lb.next-lb.interval()*int64(cost))
+}
+
+func (lb *leakyBucket) setLimit(limit int) {
+	lb.limit = limit
+}
+
+func (lb *leakyBucket) capacity() int {
+	return lb.maxDepth
+}
+
+func (lb *leakyBucket) remaining(now time.Time) int {
+	delay := max(lb.next, now.UnixNano()) - now.UnixNano()
+	return max(0, lb.maxDepth-lb.depth(delay))
+}
+
+// depth is the number of queued requests that still have to leave.
+func (lb *leakyBucket) depth(delay int64) int {
+	interval := lb.interval()
+	return int((delay + interval - 1) / interval)
+}
+
+func (lb *leakyBucket) state() LimiterState {
+	return LimiterState{
+		Algorithm: LeakyBucket,
+		Limit:     lb.limit,
+		Window:    int64(lb.window),
+		Burst:     lb.maxDepth,
+		TAT:       lb.next,
+	}
+}
+
+func restoreLeakyBucket(state LimiterState) *leakyBucket {
+	return &leakyBucket{
+		limit:    state.Limit,
+		window:   time.Duration(state.Window),
+		maxDepth: state.Burst,
+		next:     state.TAT,
+	}
+}
@@ -0,0 +1,270 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidPolicy = errors.New("invalid rate limit policy")
+
+// Rule is a single limit of a policy, such as 10 requests per second.
+type Rule struct {
+	// Name identifies the rule in decisions. It defaults to its position.
+	Name       string        `json:"name,omitempty"`
+	Algorithm  Algorithm     `json:"algorithm,omitempty"`
+	Limit      int           `json:"limit"`
+	Window     time.Duration `json:"window"`
+	Burst      int           `json:"burst"`
+	RefillRate Rate          `json:"refill_rate"`
+	// Calendar aligns a fixed_window rule to calendar boundaries in TimeZone
+	// (UTC by default). Window is ignored for such rules.
+	Calendar Calendar `json:"calendar,omitempty"`
+	TimeZone string   `json:"time_zone,omitempty"`
+}
+
+// Policy is the set of rules a client is limited by. A request is admitted
+// only if every rule admits it, which expresses limits such as "10/second and
+// 500/minute and 10,000/day". MaxConcurrent additionally caps the number of
+// leases a client may hold at once, see RateLimiter.Acquire.
+type Policy struct {
+	Rules         []Rule        `json:"rules"`
+	MaxConcurrent int           `json:"max_concurrent,omitempty"`
+	LeaseTTL      time.Duration `json:"lease_ttl,omitempty"`
+	Adaptive      *Adaptive     `json:"adaptive,omitempty"`
+	Backend       Backend       `json:"backend,omitempty"`
+}
+
+// Backend is how the increments of a policy are shared between nodes.
+type Backend string
+
+const (
+	// BackendRaft replicates every increment through the Raft log. It is the
+	// default.
+	BackendRaft Backend = "raft"
+	// BackendGossip counts increments locally and exchanges the counts between
+	// nodes over Serf. Nodes decide without waiting for each other, so a
+	// client may be over-admitted by what the other nodes admitted since their
+	// last update. Rules are counted in fixed windows aligned to the epoch.
+	BackendGossip Backend = "gossip"
+)
+
+// DefaultLeaseTTL is how long a concurrency lease lives when the policy does
+// not set LeaseTTL.
+const DefaultLeaseTTL = 30 * time.Second
+
+func DefaultPolicy() Policy {
+	return Policy{
+		Rules: []Rule{
+			{
+				Algorithm:  FixedWindow,
+				Limit:      10,
+				Window:     1 * time.Minute,
+				Burst:      10,
+				RefillRate: PerSecond(1),
+			},
+		},
+	}
+}
+
+func (p Policy) Validate() error {
+	if len(p.Rules) == 0 {
+		return fmt.Errorf("%w: no rules", ErrInvalidPolicy)
+	}
+	if p.MaxConcurrent < 0 || p.LeaseTTL < 0 {
+		return ErrInvalidPolicy
+	}
+	names := make(map[string]bool, len(p.Rules))
+	for i, rule := range p.Rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+		name := p.RuleName(i)
+		if names[name] {
+			return fmt.Errorf("%w: duplicate rule %q", ErrInvalidPolicy, name)
+		}
+		names[name] = true
+	}
+	switch p.Backend {
+	case "", BackendRaft:
+	case BackendGossip:
+		if p.Adaptive != nil {
+			return fmt.Errorf("%w: adaptive limits require the %s backend", ErrInvalidPolicy, BackendRaft)
+		}
+		for _, rule := range p.Rules {
+			if rule.Window <= 0 || rule.Calendar != "" || (rule.Algorithm != "" && rule.Algorithm != FixedWindow) {
+				return fmt.Errorf("%w: %s policies only support %s rules without calendar", ErrInvalidPolicy, BackendGossip, FixedWindow)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unknown backend %q", ErrInvalidPolicy, p.Backend)
+	}
+	if p.Adaptive != nil {
+		return p.Adaptive.validate(p.Rules[0].Limit)
+	}
+	return nil
+}
+
+// Uses reports whether any rule of the policy uses algorithm.
+func (p Policy) Uses(algorithm Algorithm) bool {
+	for _, rule := range p.Rules {
+		if rule.Algorithm == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// Limit is the smallest limit among the rules.
+func (p Policy) Limit() int {
+	limit := 0
+	for i, rule := range p.Rules {
+		if i == 0 || rule.Limit < limit {
+			limit = rule.Limit
+		}
+	}
+	return limit
+}
+
+// RuleName is the name of the i-th rule, which defaults to its position.
+func (p Policy) RuleName(i int) string {
+	if p.Rules[i].Name != "" {
+		return p.Rules[i].Name
+	}
+	return strconv.Itoa(i)
+}
+
+// LeaseTTLOrDefault is how long a concurrency lease of the policy lives.
+func (p Policy) LeaseTTLOrDefault() time.Duration {
+	if p.LeaseTTL > 0 {
+		return p.LeaseTTL
+	}
+	return DefaultLeaseTTL
+}
+
+// policyJSON shadows the duration fields of Policy so they are encoded as
+// strings such as "30s" instead of nanoseconds.
+type policyJSON struct {
+	policyAlias
+	LeaseTTL jsonDuration `json:"lease_ttl,omitempty"`
+}
+
+type policyAlias Policy
+
+func (p Policy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(policyJSON{
+		policyAlias: policyAlias(p),
+		LeaseTTL:    jsonDuration(p.LeaseTTL),
+	})
+}
+
+// UnmarshalJSON also accepts the fields of a single rule at the top level,
+// which is how policies were written before they could hold several rules.
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	var aux policyJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*p = Policy(aux.policyAlias)
+	p.LeaseTTL = time.Duration(aux.LeaseTTL)
+	if p.Rules != nil {
+		return nil
+	}
+
+	var rule Rule
+	if err := json.Unmarshal(data, &rule); err != nil {
+		return err
+	}
+	p.Rules = []Rule{rule}
+	return nil
+}
+
+func (r Rule) Validate() error {
+	if r.Limit <= 0 || r.Window < 0 || (r.Window == 0 && r.Calendar == "") {
+		return ErrInvalidPolicy
+	}
+	if r.Calendar != "" && r.Algorithm != "" && r.Algorithm != FixedWindow {
+		return fmt.Errorf("%w: calendar windows require %s", ErrInvalidPolicy, FixedWindow)
+	}
+	switch r.Algorithm {
+	case "", FixedWindow:
+		// A burst of zero disables the token bucket and leaves only the quota.
+		tokens := r.RefillRate.Tokens
+		if r.Burst < 0 || tokens < 0 || math.IsNaN(tokens) || math.IsInf(tokens, 0) || (tokens > 0 && r.RefillRate.Per <= 0) {
+			return ErrInvalidPolicy
+		}
+		if _, err := newWindowPeriod(r.Window, r.Calendar, r.TimeZone); err != nil {
+			return err
+		}
+	case SlidingWindowLog, SlidingWindowCounter:
+	case GCRA:
+		if r.Burst < 0 {
+			return ErrInvalidPolicy
+		}
+	case LeakyBucket:
+		if r.Burst <= 0 {
+			return ErrInvalidPolicy
+		}
+	default:
+		return fmt.Errorf("%w: unknown algorithm %q", ErrInvalidPolicy, r.Algorithm)
+	}
+	return nil
+}
+
+// ruleJSON shadows the duration fields of Rule so they are encoded as strings
+// such as "1m0s" instead of nanoseconds.
+type ruleJSON struct {
+	ruleAlias
+	Window jsonDuration `json:"window"`
+}
+
+type ruleAlias Rule
+
+func (r Rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ruleJSON{
+		ruleAlias: ruleAlias(r),
+		Window:    jsonDuration(r.Window),
+	})
+}
+
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	var aux ruleJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*r = Rule(aux.ruleAlias)
+	r.Window = time.Duration(aux.Window)
+	return nil
+}
+
+// jsonDuration accepts either a duration string or a number of nanoseconds.
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = jsonDuration(value)
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = jsonDuration(parsed)
+	case nil:
+		*d = 0
+	default:
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+	return nil
+}
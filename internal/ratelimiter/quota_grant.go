@@ -0,0 +1,132 @@
+package ratelimiter
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrGrantNotSupported = errors.New("policy does not support quota leases")
+
+// GrantRequest asks for a slice of a client's quota on behalf of a node.
+type GrantRequest struct {
+	NodeID string `json:"node_id"`
+	// Tokens is the size of the slice the node would like and MinTokens the
+	// least it can use. A slice is halved until it is admitted or would fall
+	// below MinTokens.
+	Tokens    int `json:"tokens"`
+	MinTokens int `json:"min_tokens"`
+	// Returned is the unused part of the node's previous slice, which is given
+	// back to the quota before the new slice is taken.
+	Returned int           `json:"returned,omitempty"`
+	TTL      time.Duration `json:"ttl"`
+}
+
+// QuotaGrant is a slice of a client's quota held by a node.
+type QuotaGrant struct {
+	Keys   []string `json:"keys"`
+	Tokens int      `json:"tokens"`
+	// Expiry is when the node stops using the slice, in Unix nanoseconds.
+	Expiry int64 `json:"expiry"`
+}
+
+// GrantQuota consumes a slice of the quota of clientID, and of every enforced
+// ancestor, for a node to hand out locally until the slice is used up or its
+// TTL expires. Each node holds at most one slice per client, so the unused
+// part of the previous one is given back first. The returned decision is
+// admitted with the size of the slice in Remaining, or rejected like an
+// increment of MinTokens.
+func (rl *RateLimiter) GrantQuota(clientID string, request GrantRequest, now time.Time) (Decision, error) {
+	if request.MinTokens <= 0 || request.Tokens < request.MinTokens {
+		return Decision{}, ErrInvalidCost
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// The previous slice is given back even if it has expired, since the node
+	// stopped handing it out at expiry.
+	if previous, exists := rl.grants[clientID][request.NodeID]; exists {
+		rl.refund(previous.Keys, min(request.Returned, previous.Tokens), now)
+		delete(rl.grants[clientID], request.NodeID)
+	}
+	grants := rl.expireGrants(clientID, now)
+	if !rl.canGrantQuota(clientID) {
+		return Decision{}, ErrGrantNotSupported
+	}
+
+	tokens := request.Tokens
+	for _, key := range rl.enforcedKeys(clientID) {
+		tokens = min(tokens, rl.clientRateLimit(key, now).capacity())
+	}
+	if tokens < request.MinTokens {
+		return Decision{}, ErrCostExceedsLimit
+	}
+
+	for {
+		decision, err := rl.allowRequest(clientID, tokens, now)
+		if err != nil {
+			return Decision{}, err
+		}
+		if decision.Allowed {
+			if grants == nil {
+				grants = make(map[string]QuotaGrant)
+				rl.grants[clientID] = grants
+			}
+			grants[request.NodeID] = QuotaGrant{
+				Keys:   rl.enforcedKeys(clientID),
+				Tokens: tokens,
+				Expiry: now.Add(request.TTL).UnixNano(),
+			}
+			decision.Remaining = tokens
+			return decision, nil
+		}
+		if tokens == request.MinTokens {
+			return decision, nil
+		}
+		tokens = max(request.MinTokens, tokens/2)
+	}
+}
+
+// CanGrantQuota reports whether slices of the quota of clientID can be
+// granted. A leaky bucket tells every request how long to delay, which a node
+// answering from its slice cannot.
+func (rl *RateLimiter) CanGrantQuota(clientID string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.canGrantQuota(clientID)
+}
+
+func (rl *RateLimiter) canGrantQuota(clientID string) bool {
+	for _, key := range rl.enforcedKeys(clientID) {
+		for _, rule := range rl.policyFor(key).Rules {
+			if rule.Algorithm == LeakyBucket {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// grantRetention is how long an expired slice is kept so that its node can
+// still give back what it did not use.
+const grantRetention = time.Minute
+
+// expireGrants drops the slices of clientID that expired more than
+// grantRetention ago. Their unused tokens are lost.
+func (rl *RateLimiter) expireGrants(clientID string, now time.Time) map[string]QuotaGrant {
+	grants, exists := rl.grants[clientID]
+	if !exists {
+		return nil
+	}
+	for nodeID, grant := range grants {
+		if grant.Expiry <= now.Add(-grantRetention).UnixNano() {
+			delete(grants, nodeID)
+		}
+	}
+	if len(grants) == 0 {
+		delete(rl.grants, clientID)
+		return nil
+	}
+	return grants
+}
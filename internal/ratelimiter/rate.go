@@ -0,0 +1,116 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is a number of tokens per period, such as 100/hour or 50/100ms.
+type Rate struct {
+	Tokens float64
+	Per    time.Duration
+}
+
+// PerSecond returns a rate of tokens every second.
+func PerSecond(tokens float64) Rate {
+	return Rate{Tokens: tokens, Per: time.Second}
+}
+
+var rateUnits = map[string]time.Duration{
+	"ns":     time.Nanosecond,
+	"us":     time.Microsecond,
+	"ms":     time.Millisecond,
+	"s":      time.Second,
+	"sec":    time.Second,
+	"second": time.Second,
+	"m":      time.Minute,
+	"min":    time.Minute,
+	"minute": time.Minute,
+	"h":      time.Hour,
+	"hour":   time.Hour,
+	"d":      24 * time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// ParseRate parses "N/period" where period is a unit such as "s", "minute"
+// or "hour", or a duration such as "100ms". A bare number is per second.
+func ParseRate(s string) (Rate, error) {
+	tokens, period, found := strings.Cut(strings.TrimSpace(s), "/")
+	n, err := strconv.ParseFloat(strings.TrimSpace(tokens), 64)
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return Rate{}, fmt.Errorf("invalid rate %q: token count is not finite", s)
+	}
+	if !found {
+		return PerSecond(n), nil
+	}
+
+	period = strings.TrimSpace(period)
+	if per, exists := rateUnits[period]; exists {
+		return Rate{Tokens: n, Per: per}, nil
+	}
+	// Accept plurals such as "hours" or "days".
+	if per, exists := rateUnits[strings.TrimSuffix(period, "s")]; exists && len(period) > 3 {
+		return Rate{Tokens: n, Per: per}, nil
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	return Rate{Tokens: n, Per: per}, nil
+}
+
+func (r Rate) String() string {
+	tokens := strconv.FormatFloat(r.Tokens, 'f', -1, 64)
+	if r.Per == 0 {
+		return tokens
+	}
+	for _, unit := range []string{"ns", "us", "ms", "s", "m", "h", "d"} {
+		if r.Per == rateUnits[unit] {
+			return tokens + "/" + unit
+		}
+	}
+	return tokens + "/" + r.Per.String()
+}
+
+// perNanosecond is the number of tokens added every nanosecond.
+func (r Rate) perNanosecond() float64 {
+	if r.Per <= 0 {
+		return 0
+	}
+	return r.Tokens / float64(r.Per)
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON accepts a rate string or, as written before rates had a
+// period, a plain number of tokens per second.
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*r = PerSecond(value)
+	case string:
+		parsed, err := ParseRate(value)
+		if err != nil {
+			return err
+		}
+		*r = parsed
+	case nil:
+		*r = Rate{}
+	default:
+		return fmt.Errorf("invalid rate %s", string(data))
+	}
+	return nil
+}
@@ -1,29 +1,45 @@
 package ratelimiter
 
 import (
+	"errors"
 	"log"
 	"sync"
 	"time"
 )
 
+var (
+	ErrInvalidCost      = errors.New("cost must be positive")
+	ErrCostExceedsLimit = errors.New("cost exceeds the capacity of the policy")
+)
+
 type RateLimitInfo struct {
 	Info map[string]*ClientRateLimit
 }
 
 type RateLimiter struct {
-	limits *RateLimitInfo
+	limits        *RateLimitInfo
+	policies      map[string]Policy
+	defaultPolicy Policy
+	// leases maps a client ID to the expiry of each of its concurrency leases.
+	leases map[string]map[string]int64
+	// adaptiveLimits holds the effective limit of the first rule of adaptive
+	// clients whose limit is below the configured one.
+	adaptiveLimits map[string]int
+	// reservations maps a client ID to its reservations that can still be
+	// cancelled, by reservation ID.
+	reservations map[string]map[string]Reservation
+	// grants maps a client ID to the quota slice each node holds.
+	grants map[string]map[string]QuotaGrant
 	mu     sync.Mutex
 }
 
 type ClientRateLimit struct {
-	Quota       *clientQuota
-	tokenBucket *TokenBucket
+	rules []clientRule
 }
 
-type clientQuota struct {
-	limit     int
-	count     int
-	resetTime time.Time
+type clientRule struct {
+	name    string
+	limiter limiter
 }
 
 func NewRateLimiter() *RateLimiter {
@@ -31,78 +47,249 @@ func NewRateLimiter() *RateLimiter {
 		limits: &RateLimitInfo{
 			Info: make(map[string]*ClientRateLimit),
 		},
+		policies:       make(map[string]Policy),
+		defaultPolicy:  DefaultPolicy(),
+		leases:         make(map[string]map[string]int64),
+		adaptiveLimits: make(map[string]int),
+		reservations:   make(map[string]map[string]Reservation),
+		grants:         make(map[string]map[string]QuotaGrant),
 	}
 }
 
-func (rl *RateLimiter) GetRateLimitInfo() *RateLimitInfo {
-	return rl.limits
+// SetDefaultPolicy sets the policy of clients without one of their own. It is
+// replicated state like the other policies, so it must only be changed through
+// the FSM.
+func (rl *RateLimiter) SetDefaultPolicy(policy Policy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.defaultPolicy = policy
+	return nil
 }
 
-func (rl *RateLimiter) CheckQuota(clientID string) int {
+func (rl *RateLimiter) GetDefaultPolicy() Policy {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.defaultPolicy
+}
+
+func (rl *RateLimiter) SetPolicy(clientID string, policy Policy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.policies[clientID] = policy
+	// The running quota was built from the previous policy, so start over.
+	delete(rl.limits.Info, clientID)
+	delete(rl.adaptiveLimits, clientID)
+	return nil
+}
+
+func (rl *RateLimiter) DeletePolicy(clientID string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if _, exists := rl.policies[clientID]; exists {
+		delete(rl.policies, clientID)
+		delete(rl.limits.Info, clientID)
+		delete(rl.adaptiveLimits, clientID)
+	}
+}
+
+func (rl *RateLimiter) GetPolicy(clientID string) Policy {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.policyFor(clientID)
+}
+
+func (rl *RateLimiter) LookupPolicy(clientID string) (Policy, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	policy, exists := rl.policies[clientID]
+	return policy, exists
+}
+
+func (rl *RateLimiter) Policies() map[string]Policy {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	policies := make(map[string]Policy, len(rl.policies))
+	for clientID, policy := range rl.policies {
+		policies[clientID] = policy
+	}
+	return policies
+}
+
+// CheckQuota reports the remaining quota of clientID at now without
+// modifying any state, so it is safe to call outside of the Raft FSM.
+func (rl *RateLimiter) CheckQuota(clientID string, now time.Time) int {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	clientRateLimit, exists := rl.limits.Info[clientID]
 	remaining := 0
-	if exists {
-		resetTime := clientRateLimit.Quota.resetTime
-		if time.Now().After(resetTime) {
-			log.Printf("Ratelimit is reset to clientID = %s", clientID)
-			clientRateLimit = rl.resetRateLimit()
-			rl.limits.Info[clientID] = clientRateLimit
+	for i, key := range rl.enforcedKeys(clientID) {
+		clientRateLimit, exists := rl.limits.Info[key]
+		if !exists {
+			// Not every algorithm starts out with its limit, e.g. a leaky
+			// bucket only queues up to its depth.
+			clientRateLimit = rl.resetRateLimit(rl.effectivePolicy(key), now)
+		}
+		r := clientRateLimit.remaining(now)
+		if i == 0 || r < remaining {
+			remaining = r
 		}
-
-		remaining = clientRateLimit.Quota.limit - clientRateLimit.Quota.count
-		remaining = max(0, remaining)
-	} else {
-		remaining = 10
 	}
 	return remaining
 }
 
-func (rl *RateLimiter) AllowRequest(clientID string) bool {
+// AllowRequest consumes cost units of the quota of clientID at now and reports
+// whether the request was allowed, the quota left after the deduction and how
+// long to wait after a rejection. A cost that could never be admitted is an
+// error rather than a rejection. For hierarchical client IDs the request also
+// consumes from every ancestor with a policy, see enforcedKeys.
+func (rl *RateLimiter) AllowRequest(clientID string, cost int, now time.Time) (Decision, error) {
+	if cost <= 0 {
+		return Decision{}, ErrInvalidCost
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.allowRequest(clientID, cost, now)
+}
+
+func (rl *RateLimiter) allowRequest(clientID string, cost int, now time.Time) (Decision, error) {
+	keys := rl.enforcedKeys(clientID)
+	clients := make([]*ClientRateLimit, len(keys))
+	for i, key := range keys {
+		clients[i] = rl.clientRateLimit(key, now)
+		if cost > clients[i].capacity() {
+			return Decision{}, ErrCostExceedsLimit
+		}
+	}
+
+	if len(clients) == 1 {
+		return clients[0].allow(now, cost), nil
+	}
+	return rl.allowHierarchy(keys, clients, now, cost), nil
+}
+
+func (rl *RateLimiter) ResetQuota(clientID string, now time.Time) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
+	if _, exists := rl.limits.Info[clientID]; exists {
+		log.Printf("Ratelimit is reset to clientID = %s", clientID)
+		rl.limits.Info[clientID] = rl.resetRateLimit(rl.effectivePolicy(clientID), now)
+	}
+
+}
+
+func (rl *RateLimiter) clientRateLimit(clientID string, now time.Time) *ClientRateLimit {
 	clientRateLimit, exists := rl.limits.Info[clientID]
 	if !exists {
-		clientRateLimit = rl.resetRateLimit()
+		clientRateLimit = rl.resetRateLimit(rl.effectivePolicy(clientID), now)
 		rl.limits.Info[clientID] = clientRateLimit
 	}
+	return clientRateLimit
+}
 
-	resetTime := clientRateLimit.Quota.resetTime
-	if time.Now().After(resetTime) {
-		log.Printf("Ratelimit is reset to clientID = %s", clientID)
-		rl.limits.Info[clientID] = rl.resetRateLimit()
+func (rl *RateLimiter) policyFor(clientID string) Policy {
+	if policy, exists := rl.policies[clientID]; exists {
+		return policy
+	}
+	return rl.defaultPolicy
+}
+
+func (rl *RateLimiter) resetRateLimit(policy Policy, now time.Time) *ClientRateLimit {
+	c := &ClientRateLimit{rules: make([]clientRule, len(policy.Rules))}
+	for i, rule := range policy.Rules {
+		c.rules[i] = clientRule{
+			name:    policy.RuleName(i),
+			limiter: newLimiter(rule, now),
+		}
 	}
+	return c
+}
 
-	quota := clientRateLimit.Quota
-	if quota.count < quota.limit && clientRateLimit.tokenBucket.tryConsume() {
-		quota.count++
-		return true
+// allow evaluates every rule atomically: the request consumes from all of
+// them or from none. When several rules reject, the one that frees up last is
+// reported since that is when the request could actually pass.
+func (c *ClientRateLimit) allow(now time.Time, cost int) Decision {
+	if len(c.rules) == 1 {
+		decision := c.rules[0].limiter.allow(now, cost)
+		if !decision.Allowed {
+			decision.Rule = c.rules[0].name
+		}
+		return decision
 	}
 
-	return false
+	// Rules that admit the request are charged for it, so check every rule
+	// first and only charge them once all of them admit it.
+	decision := c.check(now, cost)
+	if decision.Allowed {
+		for _, rule := range c.rules {
+			rule.limiter.allow(now, cost)
+		}
+	}
+	decision.Remaining = c.remaining(now)
+	return decision
 }
 
-func (rl *RateLimiter) ResetQuota(clientID string) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// check combines the decisions of every rule without charging any of them.
+func (c *ClientRateLimit) check(now time.Time, cost int) Decision {
+	decision := Decision{Allowed: true}
+	for _, rule := range c.rules {
+		ruleDecision := rule.limiter.check(now, cost)
+		ruleDecision.Rule = rule.name
+		decision = combineDecisions(decision, ruleDecision)
+	}
+	return decision
+}
 
-	if _, exists := rl.limits.Info[clientID]; exists {
-		log.Printf("Ratelimit is reset to clientID = %s", clientID)
-		rl.limits.Info[clientID] = rl.resetRateLimit()
+// combineDecisions folds next into the decision of a request that has to pass
+// several checks. A rejection wins over admission, and among rejections the
+// one that frees up last wins since only then could the request pass.
+func combineDecisions(decision, next Decision) Decision {
+	if !next.Allowed {
+		if decision.Allowed || next.RetryAfter > decision.RetryAfter {
+			return next
+		}
+		return decision
 	}
+	if decision.Allowed {
+		decision.Delay = max(decision.Delay, next.Delay)
+	}
+	return decision
+}
 
+// remaining is the quota left under the most restrictive rule.
+func (c *ClientRateLimit) remaining(now time.Time) int {
+	remaining := 0
+	for i, rule := range c.rules {
+		if r := rule.limiter.remaining(now); i == 0 || r < remaining {
+			remaining = r
+		}
+	}
+	return remaining
 }
 
-func (rl *RateLimiter) resetRateLimit() *ClientRateLimit {
-	return &ClientRateLimit{
-		Quota: &clientQuota{
-			limit:     10,
-			count:     0,
-			resetTime: time.Now().Add(1 * time.Minute),
-		},
-		tokenBucket: NewTokenBucket(10, 1),
+func (c *ClientRateLimit) capacity() int {
+	capacity := 0
+	for i, rule := range c.rules {
+		if r := rule.limiter.capacity(); i == 0 || r < capacity {
+			capacity = r
+		}
 	}
+	return capacity
 }
@@ -0,0 +1,139 @@
+package ratelimiter
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrReserveNotSupported = errors.New("policy does not support reservations")
+	ErrReservationNotFound = errors.New("reservation not found")
+)
+
+// reserver is implemented by algorithms that can book capacity in the future,
+// in the style of x/time/rate's Reserve.
+type reserver interface {
+	// reserve books cost units and returns how long the caller has to wait
+	// before using them. If the units cannot be booked it returns false and
+	// how long until they could be.
+	reserve(now time.Time, cost int) (time.Duration, bool)
+}
+
+// Reservation records what a booking consumed so that it can be given back.
+type Reservation struct {
+	Keys []string `json:"keys"`
+	Cost int      `json:"cost"`
+	// At is when the reserved units may be used, in Unix nanoseconds.
+	At int64 `json:"at"`
+}
+
+// Reserve books cost units of clientID and of every enforced ancestor and
+// returns the delay the caller has to wait before acting on them in
+// Decision.Delay. If the delay would exceed maxWait (when positive) nothing is
+// booked. Only rules whose algorithm supports reservations may be involved.
+func (rl *RateLimiter) Reserve(clientID, reservationID string, cost int, maxWait time.Duration, now time.Time) (Decision, error) {
+	if cost <= 0 {
+		return Decision{}, ErrInvalidCost
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	keys := rl.enforcedKeys(clientID)
+	candidates := make([]*ClientRateLimit, len(keys))
+	delay := time.Duration(0)
+	for i, key := range keys {
+		client := rl.clientRateLimit(key, now)
+		if cost > client.capacity() {
+			return Decision{}, ErrCostExceedsLimit
+		}
+
+		candidates[i] = restoreClientRateLimit(client.state())
+		for _, rule := range candidates[i].rules {
+			r, ok := rule.limiter.(reserver)
+			if !ok {
+				return Decision{}, ErrReserveNotSupported
+			}
+			ruleDelay, booked := r.reserve(now, cost)
+			if !booked {
+				return Decision{Allowed: false, Key: key, Rule: rule.name, RetryAfter: ruleDelay}, nil
+			}
+			delay = max(delay, ruleDelay)
+		}
+	}
+	if maxWait > 0 && delay > maxWait {
+		return Decision{Allowed: false, RetryAfter: delay - maxWait}, nil
+	}
+
+	remaining := 0
+	for i, key := range keys {
+		rl.limits.Info[key] = candidates[i]
+		if r := candidates[i].remaining(now); i == 0 || r < remaining {
+			remaining = r
+		}
+	}
+
+	reservations := rl.expireReservations(clientID, now)
+	if reservations == nil {
+		reservations = make(map[string]Reservation)
+		rl.reservations[clientID] = reservations
+	}
+	reservations[reservationID] = Reservation{
+		Keys: keys,
+		Cost: cost,
+		At:   now.Add(delay).UnixNano(),
+	}
+	return Decision{Allowed: true, Remaining: remaining, Delay: delay}, nil
+}
+
+// Cancel gives back the units of a reservation whose time has not come yet.
+// Once the reserved time has passed the reservation is considered used.
+func (rl *RateLimiter) Cancel(clientID, reservationID string, now time.Time) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	reservations := rl.expireReservations(clientID, now)
+	booked, exists := reservations[reservationID]
+	if !exists {
+		return ErrReservationNotFound
+	}
+
+	rl.refund(booked.Keys, booked.Cost, now)
+	delete(reservations, reservationID)
+	if len(reservations) == 0 {
+		delete(rl.reservations, clientID)
+	}
+	return nil
+}
+
+// refund gives cost units back to every key.
+func (rl *RateLimiter) refund(keys []string, cost int, now time.Time) {
+	for _, key := range keys {
+		client, exists := rl.limits.Info[key]
+		if !exists {
+			continue
+		}
+		for _, rule := range client.rules {
+			rule.limiter.cancel(now, cost)
+		}
+	}
+}
+
+// expireReservations drops the reservations of clientID that became usable
+// at or before now, as those can no longer be cancelled.
+func (rl *RateLimiter) expireReservations(clientID string, now time.Time) map[string]Reservation {
+	reservations, exists := rl.reservations[clientID]
+	if !exists {
+		return nil
+	}
+	for reservationID, booked := range reservations {
+		if booked.At <= now.UnixNano() {
+			delete(reservations, reservationID)
+		}
+	}
+	if len(reservations) == 0 {
+		delete(rl.reservations, clientID)
+		return nil
+	}
+	return reservations
+}
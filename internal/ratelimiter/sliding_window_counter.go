@@ -0,0 +1,123 @@
+package ratelimiter
+
+import (
+	"math"
+	"time"
+)
+
+// slidingWindowCounter approximates a sliding window with the counts of the
+// current and previous fixed windows. The previous count is weighted by how
+// much of the previous window still overlaps the trailing window, so memory
+// per client stays constant regardless of the limit.
+type slidingWindowCounter struct {
+	limit         int
+	window        time.Duration
+	windowStart   time.Time
+	count         int
+	previousCount int
+}
+
+func newSlidingWindowCounter(rule Rule, now time.Time) *slidingWindowCounter {
+	return &slidingWindowCounter{
+		limit:       rule.Limit,
+		window:      rule.Window,
+		windowStart: now.Truncate(rule.Window),
+	}
+}
+
+func (sc *slidingWindowCounter) allow(now time.Time, cost int) Decision {
+	sc.advance(now)
+	decision := sc.check(now, cost)
+	if decision.Allowed {
+		sc.count += cost
+	}
+	return decision
+}
+
+func (sc *slidingWindowCounter) check(now time.Time, cost int) Decision {
+	current := *sc
+	current.advance(now)
+	if current.estimate(now)+float64(cost) <= float64(current.limit) {
+		current.count += cost
+		return Decision{Allowed: true, Remaining: current.remaining(now)}
+	}
+	return Decision{Allowed: false, Remaining: current.remaining(now), RetryAfter: current.retryAfter(now, cost)}
+}
+
+// cancel takes cost units off the current window and, if they were admitted
+// before it started, off the previous one.
+func (sc *slidingWindowCounter) cancel(now time.Time, cost int) {
+	sc.advance(now)
+	fromCurrent := min(cost, sc.count)
+	sc.count -= fromCurrent
+	sc.previousCount = max(0, sc.previousCount-(cost-fromCurrent))
+}
+
+func (sc *slidingWindowCounter) setLimit(limit int) {
+	sc.limit = limit
+}
+
+func (sc *slidingWindowCounter) capacity() int {
+	return sc.limit
+}
+
+func (sc *slidingWindowCounter) remaining(now time.Time) int {
+	current := *sc
+	current.advance(now)
+	return max(0, sc.limit-int(math.Ceil(current.estimate(now))))
+}
+
+// advance rolls the windows forward so that windowStart contains now.
+func (sc *slidingWindowCounter) advance(now time.Time) {
+	start := now.Truncate(sc.window)
+	if !start.After(sc.windowStart) {
+		return
+	}
+	if start.Sub(sc.windowStart) == sc.window {
+		sc.previousCount = sc.count
+	} else {
+		sc.previousCount = 0
+	}
+	sc.count = 0
+	sc.windowStart = start
+}
+
+// retryAfter solves estimate(t)+cost <= limit for the earliest t. While the
+// current window alone has room, that is once enough of the previous window
+// has slid out. Otherwise it is in the next window, once enough of the current
+// one has slid out.
+func (sc *slidingWindowCounter) retryAfter(now time.Time, cost int) time.Duration {
+	start, weight, room := sc.windowStart, sc.previousCount, sc.limit-sc.count-cost
+	if room < 0 {
+		start, weight, room = start.Add(sc.window), sc.count, sc.limit-cost
+	}
+	fraction := 1 - float64(room)/float64(weight)
+	at := start.Add(time.Duration(math.Ceil(fraction * float64(sc.window))))
+	return max(0, at.Sub(now))
+}
+
+func (sc *slidingWindowCounter) estimate(now time.Time) float64 {
+	elapsed := float64(now.Sub(sc.windowStart)) / float64(sc.window)
+	return float64(sc.previousCount)*(1-elapsed) + float64(sc.count)
+}
+
+func (sc *slidingWindowCounter) state() LimiterState {
+	return LimiterState{
+		Algorithm:     SlidingWindowCounter,
+		Limit:         sc.limit,
+		Window:        int64(sc.window),
+		Count:         sc.count,
+		PreviousCount: sc.previousCount,
+		WindowStart:   sc.windowStart.UnixNano(),
+	}
+}
+
+func restoreSlidingWindowCounter(state LimiterState) *slidingWindowCounter {
+	return &slidingWindowCounter{
+		limit:         state.Limit,
+		window:        time.Duration(state.Window),
+		windowStart:   time.Unix(0, state.WindowStart),
+		count:         state.Count,
+		previousCount: state.PreviousCount,
+	}
+}
@@ -0,0 +1,101 @@
+package ratelimiter
+
+import "time"
+
+// slidingWindowLog keeps the timestamp of every admitted request and rejects
+// once the trailing window already holds limit requests. Unlike fixedWindow it
+// cannot admit twice the limit across a window boundary.
+type slidingWindowLog struct {
+	limit      int
+	window     time.Duration
+	timestamps []int64
+}
+
+func newSlidingWindowLog(rule Rule) *slidingWindowLog {
+	return &slidingWindowLog{
+		limit:  rule.Limit,
+		window: rule.Window,
+	}
+}
+
+// allow records one timestamp per unit of cost.
+func (sl *slidingWindowLog) allow(now time.Time, cost int) Decision {
+	sl.evict(now)
+	decision := sl.check(now, cost)
+	if decision.Allowed {
+		for i := 0; i < cost; i++ {
+			sl.timestamps = append(sl.timestamps, now.UnixNano())
+		}
+	}
+	return decision
+}
+
+func (sl *slidingWindowLog) check(now time.Time, cost int) Decision {
+	live := len(sl.timestamps) - sl.expired(now)
+	if live+cost <= sl.limit {
+		return Decision{Allowed: true, Remaining: sl.limit - live - cost}
+	}
+	// The newest request that has to leave the window before there is room.
+	oldest := time.Unix(0, sl.timestamps[len(sl.timestamps)-sl.limit+cost-1])
+	return Decision{
+		Allowed:    false,
+		Remaining:  max(0, sl.limit-live),
+		RetryAfter: oldest.Add(sl.window).Sub(now),
+	}
+}
+
+// cancel drops the newest timestamps, which were the last to be recorded.
+func (sl *slidingWindowLog) cancel(now time.Time, cost int) {
+	sl.evict(now)
+	sl.timestamps = sl.timestamps[:len(sl.timestamps)-min(cost, len(sl.timestamps))]
+}
+
+func (sl *slidingWindowLog) setLimit(limit int) {
+	sl.limit = limit
+}
+
+func (sl *slidingWindowLog) capacity() int {
+	return sl.limit
+}
+
+func (sl *slidingWindowLog) remaining(now time.Time) int {
+	return max(0, sl.limit-len(sl.timestamps)+sl.expired(now))
+}
+
+// evict drops the timestamps that fell out of the trailing window.
+func (sl *slidingWindowLog) evict(now time.Time) {
+	if n := sl.expired(now); n > 0 {
+		sl.timestamps = append(sl.timestamps[:0], sl.timestamps[n:]...)
+	}
+}
+
+// expired counts the leading timestamps older than the trailing window.
+func (sl *slidingWindowLog) expired(now time.Time) int {
+	boundary := now.Add(-sl.window).UnixNano()
+	n := 0
+	for n < len(sl.timestamps) && sl.timestamps[n] <= boundary {
+		n++
+	}
+	return n
+}
+
+func (sl *slidingWindowLog) state() LimiterState {
+	timestamps := make([]int64, len(sl.timestamps))
+	copy(timestamps, sl.timestamps)
+	return LimiterState{
+		Algorithm:  SlidingWindowLog,
+		Limit:      sl.limit,
+		Window:     int64(sl.window),
+		Timestamps: timestamps,
+	}
+}
+
+func restoreSlidingWindowLog(state LimiterState) *slidingWindowLog {
+	timestamps := make([]int64, len(state.Timestamps))
+	copy(timestamps, state.Timestamps)
+	return &slidingWindowLog{
+		limit:      state.Limit,
+		window:     time.Duration(state.Window),
+		timestamps: timestamps,
+	}
+}
@@ -0,0 +1,201 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+)
+
+// State is a serializable copy of the replicated state of a RateLimiter.
+type State struct {
+	// DefaultPolicy is the policy of clients without one of their own. It is
+	// missing from snapshots taken before it was replicated.
+	DefaultPolicy *Policy                `json:"default_policy,omitempty"`
+	Policies      map[string]Policy      `json:"policies"`
+	Clients       map[string]ClientState `json:"clients"`
+	// Leases holds the expiry of every concurrency lease by client and lease ID.
+	Leases map[string]map[string]int64 `json:"leases,omitempty"`
+	// AdaptiveLimits holds the effective limit of adaptive clients.
+	AdaptiveLimits map[string]int `json:"adaptive_limits,omitempty"`
+	// Reservations holds the reservations that can still be cancelled by
+	// client and reservation ID.
+	Reservations map[string]map[string]Reservation `json:"reservations,omitempty"`
+	// QuotaGrants holds the quota slices of every node by client and node ID.
+	QuotaGrants map[string]map[string]QuotaGrant `json:"quota_grants,omitempty"`
+}
+
+// ClientState holds the limiter state of every rule of a client's policy.
+type ClientState struct {
+	Rules []LimiterState `json:"rules"`
+}
+
+// LimiterState captures the limiter of a single rule. Only the fields used by
+// its algorithm are set. Times are Unix nanoseconds and durations are
+// nanoseconds so that a restored limiter is bit-for-bit identical.
+type LimiterState struct {
+	Name          string    `json:"name,omitempty"`
+	Algorithm     Algorithm `json:"algorithm,omitempty"`
+	Limit         int       `json:"limit"`
+	Window        int64     `json:"window,omitempty"`
+	Count         int       `json:"count,omitempty"`
+	ResetTime     int64     `json:"reset_time,omitempty"`
+	Tokens        float64   `json:"tokens,omitempty"`
+	MaxTokens     int       `json:"max_tokens,omitempty"`
+	RefillRate    Rate      `json:"refill_rate"`
+	LastRefill    int64     `json:"last_refill,omitempty"`
+	Timestamps    []int64   `json:"timestamps,omitempty"`
+	WindowStart   int64     `json:"window_start,omitempty"`
+	PreviousCount int       `json:"previous_count,omitempty"`
+	Burst         int       `json:"burst,omitempty"`
+	TAT           int64     `json:"tat,omitempty"`
+	Calendar      Calendar  `json:"calendar,omitempty"`
+	TimeZone      string    `json:"time_zone,omitempty"`
+}
+
+// UnmarshalJSON also accepts a single LimiterState at the top level, which is
+// how clients were snapshotted before policies could hold several rules.
+func (cs *ClientState) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Rules []LimiterState `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Rules != nil {
+		cs.Rules = aux.Rules
+		return nil
+	}
+
+	var limiterState LimiterState
+	if err := json.Unmarshal(data, &limiterState); err != nil {
+		return err
+	}
+	cs.Rules = []LimiterState{limiterState}
+	return nil
+}
+
+func (rl *RateLimiter) Snapshot() *State {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	defaultPolicy := rl.defaultPolicy
+	state := &State{
+		DefaultPolicy: &defaultPolicy,
+		Policies:      make(map[string]Policy, len(rl.policies)),
+		Clients:       make(map[string]ClientState, len(rl.limits.Info)),
+	}
+	for clientID, policy := range rl.policies {
+		state.Policies[clientID] = policy
+	}
+	for clientID, clientRateLimit := range rl.limits.Info {
+		state.Clients[clientID] = clientRateLimit.state()
+	}
+	state.Leases = copyLeases(rl.leases)
+	state.AdaptiveLimits = make(map[string]int, len(rl.adaptiveLimits))
+	for clientID, limit := range rl.adaptiveLimits {
+		state.AdaptiveLimits[clientID] = limit
+	}
+	state.Reservations = copyReservations(rl.reservations)
+	state.QuotaGrants = copyGrants(rl.grants)
+	return state
+}
+
+// Restore replaces the policies and client state with the content of state.
+func (rl *RateLimiter) Restore(state *State) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.defaultPolicy = DefaultPolicy()
+	if state.DefaultPolicy != nil {
+		rl.defaultPolicy = *state.DefaultPolicy
+	}
+	rl.policies = make(map[string]Policy, len(state.Policies))
+	for clientID, policy := range state.Policies {
+		rl.policies[clientID] = policy
+	}
+	rl.limits.Info = make(map[string]*ClientRateLimit, len(state.Clients))
+	for clientID, client := range state.Clients {
+		if !client.restorable() {
+			// Fixed windows were once snapshotted without their length and
+			// would never roll over. The client starts afresh instead.
+			log.Printf("Drop snapshotted state of %s without a window", clientID)
+			continue
+		}
+		rl.limits.Info[clientID] = restoreClientRateLimit(client)
+	}
+	rl.leases = copyLeases(state.Leases)
+	rl.adaptiveLimits = make(map[string]int, len(state.AdaptiveLimits))
+	for clientID, limit := range state.AdaptiveLimits {
+		rl.adaptiveLimits[clientID] = limit
+	}
+	rl.reservations = copyReservations(state.Reservations)
+	rl.grants = copyGrants(state.QuotaGrants)
+}
+
+func copyLeases(leases map[string]map[string]int64) map[string]map[string]int64 {
+	copied := make(map[string]map[string]int64, len(leases))
+	for clientID, clientLeases := range leases {
+		copied[clientID] = make(map[string]int64, len(clientLeases))
+		for leaseID, expiry := range clientLeases {
+			copied[clientID][leaseID] = expiry
+		}
+	}
+	return copied
+}
+
+func copyReservations(reservations map[string]map[string]Reservation) map[string]map[string]Reservation {
+	copied := make(map[string]map[string]Reservation, len(reservations))
+	for clientID, clientReservations := range reservations {
+		copied[clientID] = make(map[string]Reservation, len(clientReservations))
+		for reservationID, booked := range clientReservations {
+			copied[clientID][reservationID] = booked
+		}
+	}
+	return copied
+}
+
+func copyGrants(grants map[string]map[string]QuotaGrant) map[string]map[string]QuotaGrant {
+	copied := make(map[string]map[string]QuotaGrant, len(grants))
+	for clientID, clientGrants := range grants {
+		copied[clientID] = make(map[string]QuotaGrant, len(clientGrants))
+		for nodeID, grant := range clientGrants {
+			copied[clientID][nodeID] = grant
+		}
+	}
+	return copied
+}
+
+func (c *ClientRateLimit) state() ClientState {
+	client := ClientState{Rules: make([]LimiterState, len(c.rules))}
+	for i, rule := range c.rules {
+		client.Rules[i] = rule.limiter.state()
+		client.Rules[i].Name = rule.name
+	}
+	return client
+}
+
+// restorable reports whether every rule carries the window its algorithm
+// needs.
+func (cs ClientState) restorable() bool {
+	for _, rule := range cs.Rules {
+		if rule.Window <= 0 && rule.Calendar == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func restoreClientRateLimit(client ClientState) *ClientRateLimit {
+	c := &ClientRateLimit{rules: make([]clientRule, len(client.Rules))}
+	for i, limiterState := range client.Rules {
+		name := limiterState.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		c.rules[i] = clientRule{
+			name:    name,
+			limiter: restoreLimiter(limiterState),
+		}
+	}
+	return c
+}
@@ -1,43 +1,58 @@
 package ratelimiter
 
 import (
+	"math"
 	"time"
 )
 
+// TokenBucket holds a fractional number of tokens that is refilled with
+// nanosecond precision, so slow rates such as 1/hour and short bursts such as
+// 5/s over 300ms both accumulate correctly.
 type TokenBucket struct {
-	tokens         int
+	tokens         float64
 	maxTokens      int
-	refillRate     int
+	refillRate     Rate
 	lastRefillTime time.Time
 }
 
-func NewTokenBucket(maxTokens, refillRate int) *TokenBucket {
+func NewTokenBucket(maxTokens int, refillRate Rate, now time.Time) *TokenBucket {
 	return &TokenBucket{
-		tokens:         maxTokens,
+		tokens:         float64(maxTokens),
 		maxTokens:      maxTokens,
 		refillRate:     refillRate,
-		lastRefillTime: time.Now(),
+		lastRefillTime: now,
 	}
 }
 
-func (tb *TokenBucket) refill() {
-	now := time.Now()
-	elapsed := now.Sub(tb.lastRefillTime).Seconds()
-	newTokens := int(elapsed) * tb.refillRate
-	if newTokens > 0 {
-		tb.tokens = tb.tokens + newTokens
-		if tb.tokens > tb.maxTokens {
-			tb.tokens = tb.maxTokens
-		}
-		tb.lastRefillTime = now
+func (tb *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(tb.lastRefillTime)
+	if elapsed <= 0 {
+		return
 	}
+	tb.tokens = math.Min(float64(tb.maxTokens), tb.tokens+float64(elapsed)*tb.refillRate.perNanosecond())
+	tb.lastRefillTime = now
 }
 
-func (tb *TokenBucket) tryConsume() bool {
-	tb.refill()
-	if tb.tokens > 0 {
-		tb.tokens--
+// tryConsume takes n tokens if all of them are available and none otherwise.
+func (tb *TokenBucket) tryConsume(n int, now time.Time) bool {
+	tb.refill(now)
+	if tb.tokens >= float64(n) {
+		tb.tokens -= float64(n)
 		return true
 	}
 	return false
 }
+
+// nextRefill returns how long until the bucket holds n tokens, or zero if the
+// bucket never refills.
+func (tb *TokenBucket) nextRefill(n int, now time.Time) time.Duration {
+	rate := tb.refillRate.perNanosecond()
+	if rate <= 0 {
+		return 0
+	}
+	missing := float64(n) - tb.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return tb.lastRefillTime.Add(time.Duration(math.Ceil(missing / rate))).Sub(now)
+}